@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	// compatManifestFilename is the route manifest of the current run, to be
+	// used as the next compat_baseline.
+	compatManifestFilename = "rest.routes.json"
+	// compatReportFilename holds the differences against compat_baseline.
+	compatReportFilename = "rest.compat.json"
+)
+
+// restRoute is one entry of the route manifest. Summary is informational and
+// ignored when comparing routes.
+type restRoute struct {
+	FullMethod string `json:"full_method"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Deprecated bool   `json:"deprecated,omitempty"`
+	Summary    string `json:"summary,omitempty"`
+}
+
+func (r restRoute) key() string {
+	return r.FullMethod + " " + r.Method + " " + r.Path
+}
+
+// compatReport lists the routes added, removed and changed since the baseline.
+// A route whose method or path changed shows up as removed and added.
+type compatReport struct {
+	Added   []restRoute `json:"added"`
+	Removed []restRoute `json:"removed"`
+	Changed []restRoute `json:"changed"`
+}
+
+// generateCompatReport compares the routes of all generated files with the
+// manifest named by compat_baseline and writes both the current manifest and
+// the report. Under compat_strict removed routes fail the run.
+func generateCompatReport(gen *protogen.Plugin) error {
+	data, err := os.ReadFile(*compatBaseline)
+	if err != nil {
+		return fmt.Errorf("read compat baseline: %w", err)
+	}
+	var baseline []restRoute
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return fmt.Errorf("parse compat baseline %s: %w", *compatBaseline, err)
+	}
+	current := collectRoutes(gen)
+	report := diffRoutes(baseline, current)
+	if err := writeJSONFile(gen, compatManifestFilename, current); err != nil {
+		return err
+	}
+	if err := writeJSONFile(gen, compatReportFilename, report); err != nil {
+		return err
+	}
+	if *compatStrict && len(report.Removed) != 0 {
+		removed := make([]string, 0, len(report.Removed))
+		for _, route := range report.Removed {
+			removed = append(removed, route.key())
+		}
+		return fmt.Errorf("breaking changes against %s, removed routes: %s", *compatBaseline, strings.Join(removed, ", "))
+	}
+	return nil
+}
+
+// collectRoutes returns the routes registered by the ServiceDesc of every
+// generated file, sorted by full method, method and path.
+func collectRoutes(gen *protogen.Plugin) []restRoute {
+	routes := make([]restRoute, 0)
+	for _, file := range gen.Files {
+		if !file.Generate {
+			continue
+		}
+		for _, service := range file.Services {
+			routes = append(routes, serviceRoutes(service)...)
+		}
+	}
+	sortRoutes(routes)
+	return routes
+}
+
+// serviceRoutes returns the routes registered by the service's ServiceDesc,
+// in declaration order.
+func serviceRoutes(service *protogen.Service) []restRoute {
+	var routes []restRoute
+	for _, method := range service.Methods {
+		if !servedOverRest(method) {
+			continue
+		}
+		deprecated := methodDeprecated(method)
+		for _, binding := range methodBindings(method) {
+			routes = append(routes, restRoute{
+				FullMethod: fullMethodName(method),
+				Method:     binding.httpMethod,
+				Path:       binding.path,
+				Deprecated: deprecated,
+				Summary:    methodSummary(method),
+			})
+		}
+	}
+	return routes
+}
+
+func diffRoutes(baseline, current []restRoute) *compatReport {
+	report := &compatReport{
+		Added:   make([]restRoute, 0),
+		Removed: make([]restRoute, 0),
+		Changed: make([]restRoute, 0),
+	}
+	baselineRoutes := make(map[string]restRoute, len(baseline))
+	for _, route := range baseline {
+		baselineRoutes[route.key()] = route
+	}
+	for _, route := range current {
+		old, ok := baselineRoutes[route.key()]
+		if !ok {
+			report.Added = append(report.Added, route)
+			continue
+		}
+		if old.Deprecated != route.Deprecated {
+			report.Changed = append(report.Changed, route)
+		}
+		delete(baselineRoutes, route.key())
+	}
+	for _, route := range baselineRoutes {
+		report.Removed = append(report.Removed, route)
+	}
+	sortRoutes(report.Removed)
+	return report
+}
+
+func sortRoutes(routes []restRoute) {
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].key() < routes[j].key()
+	})
+}
+
+func writeJSONFile(gen *protogen.Plugin, filename string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", filename, err)
+	}
+	g := gen.NewGeneratedFile(filename, "")
+	if _, err := g.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write %s: %w", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDiffRoutes(t *testing.T) {
+	getUser := restRoute{FullMethod: "api.v1.UserService.GetUser", Method: "GET", Path: "/users/{id}"}
+	with := func(route restRoute, edit func(*restRoute)) restRoute {
+		edit(&route)
+		return route
+	}
+	tests := []struct {
+		name              string
+		baseline, current []restRoute
+		want              compatReport
+	}{
+		{
+			name:     "unchanged",
+			baseline: []restRoute{getUser},
+			current:  []restRoute{with(getUser, func(r *restRoute) { r.Summary = "Gets a user." })},
+		},
+		{
+			name:    "added",
+			current: []restRoute{getUser},
+			want:    compatReport{Added: []restRoute{getUser}},
+		},
+		{
+			name:     "removed",
+			baseline: []restRoute{getUser},
+			want:     compatReport{Removed: []restRoute{getUser}},
+		},
+		{
+			name:     "method changed",
+			baseline: []restRoute{getUser},
+			current:  []restRoute{with(getUser, func(r *restRoute) { r.Method = "POST" })},
+			want: compatReport{
+				Added:   []restRoute{with(getUser, func(r *restRoute) { r.Method = "POST" })},
+				Removed: []restRoute{getUser},
+			},
+		},
+		{
+			name:     "path changed",
+			baseline: []restRoute{getUser},
+			current:  []restRoute{with(getUser, func(r *restRoute) { r.Path = "/v2/users/{id}" })},
+			want: compatReport{
+				Added:   []restRoute{with(getUser, func(r *restRoute) { r.Path = "/v2/users/{id}" })},
+				Removed: []restRoute{getUser},
+			},
+		},
+		{
+			name:     "deprecated",
+			baseline: []restRoute{getUser},
+			current:  []restRoute{with(getUser, func(r *restRoute) { r.Deprecated = true })},
+			want:     compatReport{Changed: []restRoute{with(getUser, func(r *restRoute) { r.Deprecated = true })}},
+		},
+	}
+	for _, test := range tests {
+		got := diffRoutes(test.baseline, test.current)
+		for _, routes := range []*[]restRoute{&test.want.Added, &test.want.Removed, &test.want.Changed} {
+			if *routes == nil {
+				*routes = []restRoute{}
+			}
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%s: diffRoutes = %+v, want %+v", test.name, *got, test.want)
+		}
+	}
+}
+
+// writeBaseline writes routes to a compat_baseline file and returns its path.
+func writeBaseline(t *testing.T, routes []restRoute) string {
+	t.Helper()
+	data, err := json.Marshal(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseline := filepath.Join(t.TempDir(), compatManifestFilename)
+	if err := os.WriteFile(baseline, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return baseline
+}
+
+// generatedJSON decodes the named generated file into v.
+func generatedJSON(t *testing.T, content, name string, v any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(content), v); err != nil {
+		t.Fatalf("%s is not valid JSON: %v\n%s", name, err, content)
+	}
+}
+
+func TestCompatReport(t *testing.T) {
+	getUser := testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}"))
+	getUser.Options.Deprecated = proto.Bool(true)
+	file := userFile(
+		getUser,
+		testMethod("CreateUser", "CreateUserRequest", "User", httpPost("/users", "user")),
+	)
+	var (
+		getRoute    = restRoute{FullMethod: "api.v1.UserService.GetUser", Method: "GET", Path: "/api/v1/users/{id}"}
+		createRoute = restRoute{FullMethod: "api.v1.UserService.CreateUser", Method: "POST", Path: "/api/v1/users"}
+		deleteRoute = restRoute{FullMethod: "api.v1.UserService.DeleteUser", Method: "DELETE", Path: "/api/v1/users/{id}"}
+	)
+	deprecatedGetRoute := getRoute
+	deprecatedGetRoute.Deprecated = true
+
+	baseline := writeBaseline(t, []restRoute{getRoute, deleteRoute})
+	resp := runPlugin(t, "compat_baseline="+baseline, file)
+	var manifest []restRoute
+	generatedJSON(t, generatedContent(t, resp, compatManifestFilename), compatManifestFilename, &manifest)
+	if want := []restRoute{createRoute, deprecatedGetRoute}; !reflect.DeepEqual(manifest, want) {
+		t.Errorf("%s = %+v, want %+v", compatManifestFilename, manifest, want)
+	}
+	var report compatReport
+	generatedJSON(t, generatedContent(t, resp, compatReportFilename), compatReportFilename, &report)
+	want := compatReport{
+		Added:   []restRoute{createRoute},
+		Removed: []restRoute{deleteRoute},
+		Changed: []restRoute{deprecatedGetRoute},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("%s = %+v, want %+v", compatReportFilename, report, want)
+	}
+
+	wantError(t, runPlugin(t, "compat_strict=true,compat_baseline="+baseline, file),
+		"breaking changes against "+baseline+", removed routes: api.v1.UserService.DeleteUser DELETE /api/v1/users/{id}")
+	if resp := runPlugin(t, "compat_strict=true,compat_baseline="+writeBaseline(t, []restRoute{getRoute}), file); resp.Error != nil {
+		t.Errorf("compat_strict failed without removed routes: %s", resp.GetError())
+	}
+}
+
+func TestCompatFirstRun(t *testing.T) {
+	file := userFile(testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")))
+	resp := runPlugin(t, "compat_strict=true,compat_baseline="+writeBaseline(t, []restRoute{}), file)
+	var report compatReport
+	generatedJSON(t, generatedContent(t, resp, compatReportFilename), compatReportFilename, &report)
+	want := compatReport{
+		Added:   []restRoute{{FullMethod: "api.v1.UserService.GetUser", Method: "GET", Path: "/api/v1/users/{id}"}},
+		Removed: []restRoute{},
+		Changed: []restRoute{},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("%s = %+v, want %+v", compatReportFilename, report, want)
+	}
+
+	missing := filepath.Join(t.TempDir(), compatManifestFilename)
+	wantError(t, runPlugin(t, "compat_baseline="+missing, file), "read compat baseline: ", missing)
+	if err := os.WriteFile(missing, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wantError(t, runPlugin(t, "compat_baseline="+missing, file), "parse compat baseline "+missing)
+}
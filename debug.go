@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/asjard/genproto/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+)
+
+// dumpHTTPAnnotations writes, for every method of the generated files,
+// whether the http extension was found, its raw value and the bindings
+// resolved from it. It helps diagnosing annotations that silently resolve
+// to nothing, e.g. because a different http.proto was imported.
+func dumpHTTPAnnotations(gen *protogen.Plugin) {
+	w := os.Stderr
+	for _, file := range gen.Files {
+		if !file.Generate {
+			continue
+		}
+		for _, service := range file.Services {
+			for _, method := range service.Methods {
+				options := method.Desc.Options()
+				fmt.Fprintf(w, "%s (%s):\n", fullMethodName(method), methodPosition(method))
+				if !proto.HasExtension(options, annotations.E_Http) {
+					fmt.Fprintf(w, "  extension %s: not found\n", annotations.E_Http.TypeDescriptor().FullName())
+					continue
+				}
+				value := proto.GetExtension(options, annotations.E_Http)
+				httpOptions, ok := value.([]*annotations.Http)
+				if !ok {
+					fmt.Fprintf(w, "  extension %s: unexpected value type %T\n", annotations.E_Http.TypeDescriptor().FullName(), value)
+					continue
+				}
+				fmt.Fprintf(w, "  extension %s: %d value(s)\n", annotations.E_Http.TypeDescriptor().FullName(), len(httpOptions))
+				for i, httpOption := range httpOptions {
+					fmt.Fprintf(w, "  [%d] %s\n", i, prototext.MarshalOptions{}.Format(httpOption))
+				}
+				for _, binding := range methodBindings(method) {
+					fmt.Fprintf(w, "  binding: %s %s body=%q\n", binding.httpMethod, binding.path, binding.body)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// Values of the desc_format option.
+const (
+	// descFormatLegacy joins the non-blank comment lines with desc_separator
+	// and ends them with desc_terminator, "," and "." by default. Methods
+	// without a comment get an empty Desc.
+	//
+	// Deprecated: kept as the default for compatibility, use one of the other formats.
+	descFormatLegacy = ""
+	// descFormatRaw keeps the comment as written, one line per line.
+	descFormatRaw = "raw"
+	// descFormatOneline keeps the first sentence of the comment on one line.
+	descFormatOneline = "oneline"
+	// descFormatNone leaves Desc empty.
+	descFormatNone = "none"
+)
+
+func validDescFormat(format string) bool {
+	switch format {
+	case descFormatLegacy, descFormatRaw, descFormatOneline, descFormatNone:
+		return true
+	}
+	return false
+}
+
+// methodDescription returns the Desc of the method's MethodDesc entries,
+// derived from its leading comment according to desc_format. The result is
+// unquoted, callers emit it through strconv.Quote. strip_comments
+// overrides desc_format and always yields an empty Desc.
+func methodDescription(method *protogen.Method) string {
+	if *stripComments {
+		return ""
+	}
+	switch *descFormat {
+	case descFormatRaw:
+		return strings.Join(commentLines(method.Comments.Leading), "\n")
+	case descFormatOneline:
+		return firstSentence(strings.Join(commentLines(method.Comments.Leading), " "))
+	case descFormatNone:
+		return ""
+	}
+	var lines []string
+	for _, line := range commentLines(method.Comments.Leading) {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, *descSeparator) + *descTerminator
+}
+
+// methodSummary returns the first sentence of the method's leading comment,
+// or nothing under strip_comments.
+func methodSummary(method *protogen.Method) string {
+	if *stripComments {
+		return ""
+	}
+	return firstSentence(strings.Join(commentLines(method.Comments.Leading), " "))
+}
+
+// commentLines splits a comment into its lines, dropping the single space
+// conventionally written after "//" and any trailing blank lines.
+func commentLines(comments protogen.Comments) []string {
+	lines := strings.Split(strings.TrimRight(string(comments), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(strings.TrimPrefix(line, " "), " \t")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// firstSentence returns s up to and including the first period followed by
+// a space, collapsing runs of whitespace.
+func firstSentence(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if i := strings.Index(s, ". "); i >= 0 {
+		return s[:i+1]
+	}
+	return s
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+func commentedMethod(leading string) *protogen.Method {
+	return &protogen.Method{Comments: protogen.CommentSet{Leading: protogen.Comments(leading)}}
+}
+
+func TestDescSeparatorAndTerminator(t *testing.T) {
+	const comment = " Gets a user.\n Fails if it doesn't exist\n"
+	tests := []struct {
+		separator, terminator string
+		want                  string
+	}{
+		{separator: ",", terminator: ".", want: "Gets a user.,Fails if it doesn't exist."},
+		{separator: " ", terminator: ".", want: "Gets a user. Fails if it doesn't exist."},
+		{separator: " ", terminator: "", want: "Gets a user. Fails if it doesn't exist"},
+		{separator: "", terminator: "", want: "Gets a user.Fails if it doesn't exist"},
+		{separator: "\n", terminator: "!", want: "Gets a user.\nFails if it doesn't exist!"},
+	}
+	for _, test := range tests {
+		resetFlags()
+		*descSeparator = test.separator
+		*descTerminator = test.terminator
+		if got := methodDescription(commentedMethod(comment)); got != test.want {
+			t.Errorf("desc_separator=%q desc_terminator=%q: got %q, want %q", test.separator, test.terminator, got, test.want)
+		}
+	}
+}
+
+func TestMethodDescription(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		want    string
+	}{
+		{"empty", "", ""},
+		{"blank", " \n\n", ""},
+		{"single line", " Gets a user\n", "Gets a user."},
+		{"multi-line", " Gets a user\n by id\n", "Gets a user,by id."},
+		{"blank line", " Gets a user\n\n By id\n", "Gets a user,By id."},
+		{"trailing blank lines", " Gets a user\n\n\n", "Gets a user."},
+		{"quotes", " Says \"hi\" \\o/\n", "Says \"hi\" \\o/."},
+	}
+	for _, test := range tests {
+		resetFlags()
+		if got := methodDescription(commentedMethod(test.comment)); got != test.want {
+			t.Errorf("%s: methodDescription(%q) = %q, want %q", test.name, test.comment, got, test.want)
+		}
+	}
+}
+
+func TestDescFormat(t *testing.T) {
+	const comment = " Gets a user. Fails if it\n doesn't exist.\n\n See Users.\n"
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"", "Gets a user. Fails if it,doesn't exist.,See Users.."},
+		{"raw", "Gets a user. Fails if it\ndoesn't exist.\n\nSee Users."},
+		{"oneline", "Gets a user."},
+		{"none", ""},
+	}
+	for _, test := range tests {
+		resetFlags()
+		*descFormat = test.format
+		if got := methodDescription(commentedMethod(comment)); got != test.want {
+			t.Errorf("desc_format=%q: got %q, want %q", test.format, got, test.want)
+		}
+		resetFlags()
+		*descFormat = test.format
+		*stripComments = true
+		if got := methodDescription(commentedMethod(comment)); got != "" {
+			t.Errorf("desc_format=%q strip_comments=true: got %q, want an empty Desc", test.format, got)
+		}
+	}
+
+	file := userFile(testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")))
+	locateMethod(file, 0, 0, 10, comment)
+	content := generatedContent(t, runPlugin(t, "desc_format=raw", file), userOutput)
+	wantContains(t, content, `Desc: "Gets a user. Fails if it\ndoesn't exist.\n\nSee Users.",`)
+
+	wantError(t, runPlugin(t, "desc_format=markdown", file), `desc_format "markdown" is not one of raw, oneline or none`)
+}
+
+func TestCommentLines(t *testing.T) {
+	tests := []struct {
+		comment string
+		want    []string
+	}{
+		{"", []string{}},
+		{" Gets a user\n", []string{"Gets a user"}},
+		{" Gets a user\n\n  indented\t\n", []string{"Gets a user", "", " indented"}},
+		{" Gets a user\n\n\n", []string{"Gets a user"}},
+	}
+	for _, test := range tests {
+		if got := commentLines(protogen.Comments(test.comment)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("commentLines(%q) = %q, want %q", test.comment, got, test.want)
+		}
+	}
+}
+
+func TestDescIsQuoted(t *testing.T) {
+	file := userFile(testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")))
+	locateMethod(file, 0, 0, 10, " Says \"hi\" \\o/\n")
+	content := generatedContent(t, runPlugin(t, "", file), userOutput)
+	wantContains(t, content, `Desc: "Says \"hi\" \\o/.",`)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/asjard/genproto/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// lroRoute is the conventional REST mapping of a google.longrunning.Operations
+// method. Paths are relative to the package prefix added by restFullPath.
+type lroRoute struct {
+	input  protoreflect.FullName
+	output protoreflect.FullName
+	http   *annotations.Http
+}
+
+// lroRoutesByMethod follows the bindings google.longrunning.Operations
+// declares, keyed by method name.
+var lroRoutesByMethod = map[protoreflect.Name]lroRoute{
+	"ListOperations": {
+		input:  "google.longrunning.ListOperationsRequest",
+		output: "google.longrunning.ListOperationsResponse",
+		http:   &annotations.Http{Pattern: &annotations.Http_Get{Get: "/{name=operations}"}},
+	},
+	"GetOperation": {
+		input:  "google.longrunning.GetOperationRequest",
+		output: "google.longrunning.Operation",
+		http:   &annotations.Http{Pattern: &annotations.Http_Get{Get: "/{name=operations/**}"}},
+	},
+	"DeleteOperation": {
+		input:  "google.longrunning.DeleteOperationRequest",
+		output: "google.protobuf.Empty",
+		http:   &annotations.Http{Pattern: &annotations.Http_Delete{Delete: "/{name=operations/**}"}},
+	},
+	"CancelOperation": {
+		input:  "google.longrunning.CancelOperationRequest",
+		output: "google.protobuf.Empty",
+		http:   &annotations.Http{Pattern: &annotations.Http_Post{Post: "/{name=operations/**}:cancel"}, Body: "*"},
+	},
+}
+
+// lroBinding returns the conventional binding of an unannotated method whose
+// name and signature match a google.longrunning.Operations method, or nil.
+// It is built like an annotated one, path variables included.
+func lroBinding(method *protogen.Method) *restBinding {
+	route, ok := lroRoutesByMethod[method.Desc.Name()]
+	if !ok ||
+		method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() ||
+		method.Input.Desc.FullName() != route.input ||
+		method.Output.Desc.FullName() != route.output {
+		return nil
+	}
+	return newRestBinding(method, route.http)
+}
@@ -36,6 +36,8 @@ package main
 import (
 	"flag"
 	"fmt"
+	"go/token"
+	"strings"
 
 	"google.golang.org/protobuf/compiler/protogen"
 	"google.golang.org/protobuf/types/pluginpb"
@@ -43,8 +45,34 @@ import (
 
 const version = "1.3.0"
 
+// flags holds the plugin parameters passed through --go-rest_opt.
+var flags flag.FlagSet
+
 var requireUnimplemented *bool
 var useGenericStreams *bool
+var mergePatch *bool
+var compatBaseline *string
+var compatStrict *bool
+var packageSuffix *string
+var tracing *bool
+var descSelfcheck *bool
+var implicitBody *bool
+var sourceRefs *bool
+var lroRoutes *bool
+var descFormat *string
+var stripComments *bool
+var descSeparator *string
+var descTerminator *string
+var handlerVars *bool
+var formBody *bool
+var embedRoutesJSON *bool
+var dumpDescriptorSet *bool
+var serverInfoRegistry *bool
+var openapiOut *bool
+var maxQueryBytes *int
+var versionGrouping *bool
+var filenameSuffix *string
+var serverStreams *bool
 
 func main() {
 	showVersion := flag.Bool("version", false, "print the version and exit")
@@ -54,20 +82,85 @@ func main() {
 		return
 	}
 
-	var flags flag.FlagSet
-	requireUnimplemented = flags.Bool("require_unimplemented_servers", true, "set to false to match legacy behavior")
-	useGenericStreams = flags.Bool("use_generic_streams_experimental", false, "set to true to use generic types for streaming client and server objects; this flag is EXPERIMENTAL and may be changed or removed in a future release")
+	defineFlags()
 
 	protogen.Options{
 		ParamFunc: flags.Set,
-	}.Run(func(gen *protogen.Plugin) error {
-		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
-		for _, f := range gen.Files {
-			if !f.Generate {
-				continue
-			}
-			generateFile(gen, f)
+	}.Run(generate)
+}
+
+// defineFlags registers the plugin parameters on flags.
+func defineFlags() {
+	requireUnimplemented = flags.Bool("require_unimplemented_servers", true, "set to false to match legacy behavior")
+	useGenericStreams = flags.Bool("use_generic_streams_experimental", false, "set to true to use generic types for streaming client and server objects; this flag is EXPERIMENTAL and may be changed or removed in a future release")
+	mergePatch = flags.Bool("merge_patch", false, "set to true to accept RFC 7386 JSON Merge Patch bodies on PATCH methods")
+	compatBaseline = flags.String("compat_baseline", "", "route manifest of a previous run to report API changes against; an empty JSON array bootstraps it")
+	compatStrict = flags.Bool("compat_strict", false, "set to true to fail when routes were removed or moved since compat_baseline")
+	tracing = flags.Bool("tracing", false, "set to true to record the route template and full method name as attributes of the current span; the span is read with go.opentelemetry.io/otel/trace, other tracers are not supported")
+	descSelfcheck = flags.Bool("desc_selfcheck", false, "set to true to verify every generated ServiceDesc at package init")
+	implicitBody = flags.Bool("implicit_body", false, "set to true to bind the whole message from the body of POST, PUT and PATCH bindings that declare no body; off matches grpc-gateway")
+	sourceRefs = flags.Bool("source_refs", false, "set to true to annotate handlers and MethodDesc entries with the proto source line they come from")
+	lroRoutes = flags.Bool("lro_routes", false, "set to true to route unannotated google.longrunning.Operations-shaped methods to their conventional paths")
+	descFormat = flags.String("desc_format", descFormatLegacy, "how method comments become MethodDesc.Desc: raw, oneline or none; unset keeps the deprecated comma-joined format")
+	descSeparator = flags.String("desc_separator", ",", "separator placed between comment lines in the default desc_format")
+	descTerminator = flags.String("desc_terminator", ".", "terminator appended to the last comment line in the default desc_format")
+	versionGrouping = flags.Bool("version_grouping", false, "set to true to also emit, next to each full ServiceDesc, one ServiceDesc per /v{n} prefix of the annotated paths holding only the routes of that version")
+	maxQueryBytes = flags.Int("max_query_bytes", 0, "reject query strings longer than this many bytes with 414; 0 keeps the server default")
+	openapiOut = flags.Bool("openapi_out", false, "set to true to also write an OpenAPI v3 document per file to <name>.openapi.yaml")
+	serverInfoRegistry = flags.Bool("server_info_registry", false, "set to true to emit the static UnaryServerInfo and deprecation of every method exposed over rest")
+	dumpDescriptorSet = flags.Bool("dump_descriptor_set", false, "set to true to print how the http annotation of every method resolved to stderr; for debugging only")
+	embedRoutesJSON = flags.Bool("embed_routes_json", false, "set to true to emit each service's routes as a JSON string variable")
+	formBody = flags.Bool("form_body", false, "set to true to list application/x-www-form-urlencoded in the Consumes of POST bindings with a body; decoding the form is left to the runtime")
+	handlerVars = flags.Bool("handler_vars", false, "set to true to emit handlers as package-level function variables that can be reassigned")
+	stripComments = flags.Bool("strip_comments", false, "set to true to leave proto comments out of the generated code, including MethodDesc.Desc")
+	filenameSuffix = flags.String("filename_suffix", "_rest.pb.go", "suffix replacing .proto in the names of the generated files")
+	serverStreams = flags.Bool("server_streams", false, "set to true to serve server-streaming methods as Server-Sent Events; needs a rest runtime providing ServerStream and NewServerStream, see genServerStreamMethod")
+	packageSuffix = flags.String("package_suffix", "", "emit the generated code into a sub-package with this name, next to the message package")
+}
+
+// generate writes the files for the request held by gen.
+func generate(gen *protogen.Plugin) error {
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if *packageSuffix != "" && !token.IsIdentifier(*packageSuffix) {
+		return fmt.Errorf("package_suffix %q is not a valid Go package name", *packageSuffix)
+	}
+	if !strings.HasSuffix(*filenameSuffix, ".go") || *filenameSuffix == ".pb.go" {
+		return fmt.Errorf("filename_suffix %q must end in .go and differ from protoc-gen-go's .pb.go", *filenameSuffix)
+	}
+	if *maxQueryBytes < 0 {
+		return fmt.Errorf("max_query_bytes must not be negative, got %d", *maxQueryBytes)
+	}
+	if !validDescFormat(*descFormat) {
+		return fmt.Errorf("desc_format %q is not one of raw, oneline or none", *descFormat)
+	}
+	if *dumpDescriptorSet {
+		dumpHTTPAnnotations(gen)
+	}
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+		generateFile(gen, f)
+		if *openapiOut {
+			generateOpenAPI(gen, f)
+		}
+	}
+	if *compatBaseline != "" {
+		return generateCompatReport(gen)
+	}
+	return nil
+}
+
+// effectiveOptions lists the plugin parameters that differ from their
+// defaults, sorted by name, with their values quoted so that any value fits
+// on a comment line.
+func effectiveOptions() string {
+	var options []string
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Value.String() == f.DefValue {
+			return
 		}
-		return nil
+		options = append(options, fmt.Sprintf("%s=%q", f.Name, f.Value.String()))
 	})
+	return strings.Join(options, ", ")
 }
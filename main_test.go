@@ -0,0 +1,313 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/asjard/genproto/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// resetFlags registers the plugin parameters anew, so that every test starts
+// from the defaults.
+func resetFlags() {
+	flags = flag.FlagSet{}
+	defineFlags()
+}
+
+// runPlugin runs the plugin with parameter on file, the way protoc would, and
+// returns its response. The annotations and the well-known types file may be
+// imported by file.
+func runPlugin(t *testing.T, parameter string, file *descriptorpb.FileDescriptorProto) *pluginpb.CodeGeneratorResponse {
+	t.Helper()
+	resetFlags()
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{file.GetName()},
+		Parameter:      proto.String(parameter),
+		ProtoFile: append(protoFiles(
+			annotations.E_Http.TypeDescriptor().ParentFile(),
+			emptypb.File_google_protobuf_empty_proto,
+			timestamppb.File_google_protobuf_timestamp_proto,
+		), file),
+	}
+	gen, err := protogen.Options{ParamFunc: flags.Set}.New(req)
+	if err != nil {
+		t.Fatalf("protogen.Options.New: %v", err)
+	}
+	if err := generate(gen); err != nil {
+		gen.Error(err)
+	}
+	return gen.Response()
+}
+
+// protoFiles returns the descriptors of files and their transitive imports,
+// each after its imports.
+func protoFiles(files ...protoreflect.FileDescriptor) []*descriptorpb.FileDescriptorProto {
+	var (
+		out  []*descriptorpb.FileDescriptorProto
+		seen = make(map[string]bool)
+		add  func(protoreflect.FileDescriptor)
+	)
+	add = func(file protoreflect.FileDescriptor) {
+		if seen[file.Path()] {
+			return
+		}
+		seen[file.Path()] = true
+		for i := 0; i < file.Imports().Len(); i++ {
+			add(file.Imports().Get(i).FileDescriptor)
+		}
+		out = append(out, protodesc.ToFileDescriptorProto(file))
+	}
+	for _, file := range files {
+		add(file)
+	}
+	return out
+}
+
+// generatedContent returns the content of the named generated file, failing
+// the test if the run errored or didn't produce it.
+func generatedContent(t *testing.T, resp *pluginpb.CodeGeneratorResponse, name string) string {
+	t.Helper()
+	if resp.Error != nil {
+		t.Fatalf("plugin error: %s", resp.GetError())
+	}
+	for _, file := range resp.File {
+		if file.GetName() == name {
+			return file.GetContent()
+		}
+	}
+	t.Fatalf("no %s generated, got %v", name, generatedNames(resp))
+	return ""
+}
+
+func generatedNames(resp *pluginpb.CodeGeneratorResponse) []string {
+	names := make([]string, 0, len(resp.File))
+	for _, file := range resp.File {
+		names = append(names, file.GetName())
+	}
+	return names
+}
+
+// wantError fails the test unless the run errored with a message containing
+// every one of parts.
+func wantError(t *testing.T, resp *pluginpb.CodeGeneratorResponse, parts ...string) {
+	t.Helper()
+	if resp.Error == nil {
+		t.Fatalf("plugin succeeded, want an error containing %q", parts)
+	}
+	for _, part := range parts {
+		if !strings.Contains(resp.GetError(), part) {
+			t.Errorf("plugin error %q does not contain %q", resp.GetError(), part)
+		}
+	}
+}
+
+// wantContains fails the test unless content holds every one of parts.
+// Whitespace runs compare equal, so that parts don't depend on gofmt's
+// alignment.
+func wantContains(t *testing.T, content string, parts ...string) {
+	t.Helper()
+	for _, part := range parts {
+		if !strings.Contains(collapseSpace(content), collapseSpace(part)) {
+			t.Errorf("generated code does not contain %q:\n%s", part, content)
+		}
+	}
+}
+
+// wantNotContains fails the test if content holds any of parts.
+func wantNotContains(t *testing.T, content string, parts ...string) {
+	t.Helper()
+	for _, part := range parts {
+		if strings.Contains(collapseSpace(content), collapseSpace(part)) {
+			t.Errorf("generated code contains %q:\n%s", part, content)
+		}
+	}
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// testFile returns a proto3 file declaring messages and services in pkg.
+// Message and method type names without a leading dot are qualified with
+// pkg.
+func testFile(name, pkg string, messages []*descriptorpb.DescriptorProto, services ...*descriptorpb.ServiceDescriptorProto) *descriptorpb.FileDescriptorProto {
+	qualify := func(typeName *string) *string {
+		if typeName == nil || strings.HasPrefix(*typeName, ".") {
+			return typeName
+		}
+		if pkg == "" {
+			return proto.String("." + *typeName)
+		}
+		return proto.String("." + pkg + "." + *typeName)
+	}
+	for _, message := range messages {
+		for _, field := range message.Field {
+			field.TypeName = qualify(field.TypeName)
+		}
+		for _, nested := range message.NestedType {
+			for _, field := range nested.Field {
+				field.TypeName = qualify(field.TypeName)
+			}
+		}
+	}
+	for _, service := range services {
+		for _, method := range service.Method {
+			method.InputType = qualify(method.InputType)
+			method.OutputType = qualify(method.OutputType)
+		}
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String(name),
+		Syntax:      proto.String("proto3"),
+		Dependency:  []string{annotations.E_Http.TypeDescriptor().ParentFile().Path()},
+		Options:     &descriptorpb.FileOptions{GoPackage: proto.String("example.com/api;api")},
+		MessageType: messages,
+		Service:     services,
+	}
+	if pkg != "" {
+		file.Package = proto.String(pkg)
+	}
+	return file
+}
+
+// locateMethod records the source line and leading comment of a method of
+// file, as protoc does in SourceCodeInfo. line is zero-based.
+func locateMethod(file *descriptorpb.FileDescriptorProto, service, method int, line int32, leading string) {
+	if file.SourceCodeInfo == nil {
+		file.SourceCodeInfo = &descriptorpb.SourceCodeInfo{}
+	}
+	location := &descriptorpb.SourceCodeInfo_Location{
+		Path: []int32{6, int32(service), 2, int32(method)},
+		Span: []int32{line, 2, 60},
+	}
+	if leading != "" {
+		location.LeadingComments = proto.String(leading)
+	}
+	file.SourceCodeInfo.Location = append(file.SourceCodeInfo.Location, location)
+}
+
+func testMessage(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	for i, field := range fields {
+		field.Number = proto.Int32(int32(i + 1))
+	}
+	return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+}
+
+func stringField(name string) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:  proto.String(name),
+		Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:  descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+	}
+}
+
+func messageField(name, typeName string) *descriptorpb.FieldDescriptorProto {
+	field := stringField(name)
+	field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	field.TypeName = proto.String(typeName)
+	return field
+}
+
+func repeatedField(field *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return field
+}
+
+func testService(name string, methods ...*descriptorpb.MethodDescriptorProto) *descriptorpb.ServiceDescriptorProto {
+	return &descriptorpb.ServiceDescriptorProto{Name: proto.String(name), Method: methods}
+}
+
+// testMethod returns a unary method annotated with httpOptions.
+func testMethod(name, input, output string, httpOptions ...*annotations.Http) *descriptorpb.MethodDescriptorProto {
+	options := &descriptorpb.MethodOptions{}
+	if len(httpOptions) != 0 {
+		proto.SetExtension(options, annotations.E_Http, httpOptions)
+	}
+	return &descriptorpb.MethodDescriptorProto{
+		Name:       proto.String(name),
+		InputType:  proto.String(input),
+		OutputType: proto.String(output),
+		Options:    options,
+	}
+}
+
+func httpGet(path string) *annotations.Http {
+	return &annotations.Http{Pattern: &annotations.Http_Get{Get: path}}
+}
+
+func httpPost(path, body string) *annotations.Http {
+	return &annotations.Http{Pattern: &annotations.Http_Post{Post: path}, Body: body}
+}
+
+func httpPut(path, body string) *annotations.Http {
+	return &annotations.Http{Pattern: &annotations.Http_Put{Put: path}, Body: body}
+}
+
+func httpDelete(path string) *annotations.Http {
+	return &annotations.Http{Pattern: &annotations.Http_Delete{Delete: path}}
+}
+
+func httpPatch(path, body string) *annotations.Http {
+	return &annotations.Http{Pattern: &annotations.Http_Patch{Patch: path}, Body: body}
+}
+
+// userFile is the api.v1 user service most tests generate from.
+func userFile(methods ...*descriptorpb.MethodDescriptorProto) *descriptorpb.FileDescriptorProto {
+	return testFile("user.proto", "api.v1",
+		[]*descriptorpb.DescriptorProto{
+			testMessage("User", stringField("id"), stringField("name")),
+			testMessage("GetUserRequest", stringField("id")),
+			testMessage("CreateUserRequest", messageField("user", "User")),
+		},
+		testService("UserService", methods...),
+	)
+}
+
+func TestEffectiveOptions(t *testing.T) {
+	baseline := writeBaseline(t, []restRoute{})
+	file := userFile(testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")))
+
+	content := generatedContent(t, runPlugin(t, "tracing=true,desc_separator=\n*/,compat_baseline="+baseline, file), userOutput)
+	want := fmt.Sprintf("\n// options: compat_baseline=%q, desc_separator=\"\\n*/\", tracing=\"true\"\n", baseline)
+	if !strings.Contains(content, want) {
+		t.Errorf("generated code lacks the options line %q:\n%s", want, content)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), userOutput, content, parser.ParseComments); err != nil {
+		t.Errorf("generated code doesn't parse: %v", err)
+	}
+
+	content = generatedContent(t, runPlugin(t, "", file), userOutput)
+	wantNotContains(t, content, "// options:")
+}
+
+func TestFilenameSuffix(t *testing.T) {
+	file := userFile(testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")))
+	generatedContent(t, runPlugin(t, "filename_suffix=.rest.go", file), "example.com/api/user.rest.go")
+
+	for _, suffix := range []string{".pb.go", "_rest.pb", ""} {
+		wantError(t, runPlugin(t, "filename_suffix="+suffix, file),
+			fmt.Sprintf("filename_suffix %q must end in .go and differ from protoc-gen-go's .pb.go", suffix))
+	}
+}
+
+func TestNoOutputWithoutBindings(t *testing.T) {
+	resp := runPlugin(t, "openapi_out=true", userFile(testMethod("GetUser", "GetUserRequest", "User")))
+	if resp.Error != nil {
+		t.Fatalf("plugin error: %s", resp.GetError())
+	}
+	if names := generatedNames(resp); len(names) != 0 {
+		t.Errorf("generated %v for a file without bindings, want nothing", names)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// openAPIVersion is the OpenAPI specification version of the generated documents.
+const openAPIVersion = "3.0.3"
+
+// generateOpenAPI writes an OpenAPI v3 document with one operation per HTTP
+// binding of the file's services to <prefix>.openapi.yaml. Like the Go file,
+// it is left out when the file has no binding.
+func generateOpenAPI(gen *protogen.Plugin, file *protogen.File) {
+	if !fileHasBindings(file) {
+		return
+	}
+	b := &openAPIBuilder{
+		schemas: make(map[string]*yamlMap),
+	}
+	paths := newYAMLMap()
+	for _, service := range file.Services {
+		for _, method := range service.Methods {
+			if !servedOverRest(method) {
+				continue
+			}
+			for i, binding := range methodBindings(method) {
+				verb := strings.ToLower(binding.httpMethod)
+				if !openAPIOperations[verb] {
+					warnf("%s: %s %s has no OpenAPI operation, left out of the document",
+						fullMethodName(method), binding.httpMethod, binding.path)
+					continue
+				}
+				operationID := service.GoName + "_" + method.GoName
+				if i != 0 {
+					operationID += "_" + strconv.Itoa(i)
+				}
+				path, vars := parsePathTemplate(binding.path)
+				var pathItem *yamlMap
+				if item, ok := paths.get(path); ok {
+					pathItem = item.(*yamlMap)
+				} else {
+					pathItem = newYAMLMap()
+					paths.set(path, pathItem)
+				}
+				pathItem.set(verb, b.operation(binding, operationID, vars))
+			}
+		}
+	}
+
+	title := string(file.Desc.Package())
+	if title == "" {
+		title = file.Desc.Path()
+	}
+	info := newYAMLMap()
+	info.set("title", title)
+	info.set("version", "1.0.0")
+	doc := newYAMLMap()
+	doc.set("openapi", openAPIVersion)
+	doc.set("info", info)
+	doc.set("paths", paths)
+	if len(b.schemas) != 0 {
+		names := make([]string, 0, len(b.schemas))
+		for name := range b.schemas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		schemas := newYAMLMap()
+		for _, name := range names {
+			schemas.set(name, b.schemas[name])
+		}
+		components := newYAMLMap()
+		components.set("schemas", schemas)
+		doc.set("components", components)
+	}
+
+	var buf bytes.Buffer
+	writeYAML(&buf, doc, 0)
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+".openapi.yaml", "")
+	if _, err := g.Write(buf.Bytes()); err != nil {
+		gen.Error(fmt.Errorf("write OpenAPI document of %s: %w", file.Desc.Path(), err))
+	}
+}
+
+// openAPIOperations are the HTTP methods an OpenAPI path item can hold.
+var openAPIOperations = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// openAPIBuilder collects the component schemas referenced by operations.
+type openAPIBuilder struct {
+	schemas map[string]*yamlMap
+}
+
+func (b *openAPIBuilder) operation(binding *restBinding, operationID string, vars []string) *yamlMap {
+	method := binding.method
+	operation := newYAMLMap()
+	operation.set("operationId", operationID)
+	if summary := methodSummary(method); summary != "" {
+		operation.set("summary", summary)
+	}
+	operation.set("tags", []any{string(method.Parent.Desc.FullName())})
+
+	if len(vars) != 0 {
+		parameters := make([]any, 0, len(vars))
+		for _, name := range vars {
+			parameter := newYAMLMap()
+			parameter.set("name", name)
+			parameter.set("in", "path")
+			parameter.set("required", true)
+			if field, err := resolveFieldPath(method.Input, name); err == nil {
+				parameter.set("schema", b.fieldSchema(field.Desc))
+			} else {
+				parameter.set("schema", typeSchema("string", ""))
+			}
+			parameters = append(parameters, parameter)
+		}
+		operation.set("parameters", parameters)
+	}
+
+	if binding.body != "" {
+		var schema *yamlMap
+		if field := method.Input.Desc.Fields().ByName(protoreflect.Name(binding.body)); field != nil {
+			schema = b.fieldSchema(field)
+		} else {
+			schema = b.messageRef(method.Input.Desc)
+		}
+		requestBody := newYAMLMap()
+		requestBody.set("content", mediaTypeContent("application/json", schema))
+		operation.set("requestBody", requestBody)
+	}
+
+	status := defaultSuccessStatus(binding.httpMethod)
+	response := newYAMLMap()
+	response.set("description", http.StatusText(status))
+	if status != http.StatusNoContent {
+		var schema *yamlMap
+		if field := method.Output.Desc.Fields().ByName(protoreflect.Name(binding.responseBody)); field != nil {
+			schema = b.fieldSchema(field)
+		} else {
+			schema = b.messageRef(method.Output.Desc)
+		}
+		if method.Desc.IsStreamingServer() {
+			response.set("content", mediaTypeContent("text/event-stream", schema))
+		} else {
+			response.set("content", mediaTypeContent("application/json", schema))
+		}
+	}
+	responses := newYAMLMap()
+	responses.set(strconv.Itoa(status), response)
+	operation.set("responses", responses)
+	return operation
+}
+
+func mediaTypeContent(name string, schema *yamlMap) *yamlMap {
+	mediaType := newYAMLMap()
+	mediaType.set("schema", schema)
+	content := newYAMLMap()
+	content.set(name, mediaType)
+	return content
+}
+
+func typeSchema(typ, format string) *yamlMap {
+	schema := newYAMLMap()
+	schema.set("type", typ)
+	if format != "" {
+		schema.set("format", format)
+	}
+	return schema
+}
+
+// fieldSchema returns the schema of a field as protojson encodes it.
+func (b *openAPIBuilder) fieldSchema(field protoreflect.FieldDescriptor) *yamlMap {
+	if field.IsMap() {
+		schema := typeSchema("object", "")
+		schema.set("additionalProperties", b.singularSchema(field.MapValue()))
+		return schema
+	}
+	if field.IsList() {
+		schema := typeSchema("array", "")
+		schema.set("items", b.singularSchema(field))
+		return schema
+	}
+	return b.singularSchema(field)
+}
+
+func (b *openAPIBuilder) singularSchema(field protoreflect.FieldDescriptor) *yamlMap {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return typeSchema("boolean", "")
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return typeSchema("integer", "int32")
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return typeSchema("integer", "int64")
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		// protojson encodes 64-bit integers as strings.
+		return typeSchema("string", "int64")
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return typeSchema("string", "uint64")
+	case protoreflect.FloatKind:
+		return typeSchema("number", "float")
+	case protoreflect.DoubleKind:
+		return typeSchema("number", "double")
+	case protoreflect.StringKind:
+		return typeSchema("string", "")
+	case protoreflect.BytesKind:
+		return typeSchema("string", "byte")
+	case protoreflect.EnumKind:
+		return b.enumRef(field.Enum())
+	default:
+		return b.messageRef(field.Message())
+	}
+}
+
+// wellKnownSchemas are the JSON representations of the well-known types
+// protojson doesn't encode as objects of their fields.
+var wellKnownSchemas = map[protoreflect.FullName]func() *yamlMap{
+	"google.protobuf.Timestamp": func() *yamlMap { return typeSchema("string", "date-time") },
+	"google.protobuf.Duration":  func() *yamlMap { return typeSchema("string", "") },
+	"google.protobuf.FieldMask": func() *yamlMap { return typeSchema("string", "") },
+	"google.protobuf.Struct": func() *yamlMap {
+		schema := typeSchema("object", "")
+		schema.set("additionalProperties", true)
+		return schema
+	},
+	"google.protobuf.Value": func() *yamlMap { return newYAMLMap() },
+	"google.protobuf.ListValue": func() *yamlMap {
+		schema := typeSchema("array", "")
+		schema.set("items", newYAMLMap())
+		return schema
+	},
+	"google.protobuf.Any": func() *yamlMap {
+		schema := typeSchema("object", "")
+		schema.set("additionalProperties", true)
+		return schema
+	},
+	"google.protobuf.Empty":       func() *yamlMap { return typeSchema("object", "") },
+	"google.protobuf.BoolValue":   func() *yamlMap { return typeSchema("boolean", "") },
+	"google.protobuf.Int32Value":  func() *yamlMap { return typeSchema("integer", "int32") },
+	"google.protobuf.UInt32Value": func() *yamlMap { return typeSchema("integer", "int64") },
+	"google.protobuf.Int64Value":  func() *yamlMap { return typeSchema("string", "int64") },
+	"google.protobuf.UInt64Value": func() *yamlMap { return typeSchema("string", "uint64") },
+	"google.protobuf.FloatValue":  func() *yamlMap { return typeSchema("number", "float") },
+	"google.protobuf.DoubleValue": func() *yamlMap { return typeSchema("number", "double") },
+	"google.protobuf.StringValue": func() *yamlMap { return typeSchema("string", "") },
+	"google.protobuf.BytesValue":  func() *yamlMap { return typeSchema("string", "byte") },
+}
+
+func schemaRef(name protoreflect.FullName) *yamlMap {
+	ref := newYAMLMap()
+	ref.set("$ref", "#/components/schemas/"+string(name))
+	return ref
+}
+
+// messageRef returns a reference to the message's component schema, adding
+// it and the schemas it depends on to the document.
+func (b *openAPIBuilder) messageRef(message protoreflect.MessageDescriptor) *yamlMap {
+	if wellKnown, ok := wellKnownSchemas[message.FullName()]; ok {
+		return wellKnown()
+	}
+	name := string(message.FullName())
+	if _, ok := b.schemas[name]; !ok {
+		schema := typeSchema("object", "")
+		// Registered before the fields so that recursive messages terminate.
+		b.schemas[name] = schema
+		properties := newYAMLMap()
+		fields := message.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			field := fields.Get(i)
+			properties.set(field.JSONName(), b.fieldSchema(field))
+		}
+		if len(properties.keys) != 0 {
+			schema.set("properties", properties)
+		}
+	}
+	return schemaRef(message.FullName())
+}
+
+func (b *openAPIBuilder) enumRef(enum protoreflect.EnumDescriptor) *yamlMap {
+	name := string(enum.FullName())
+	if _, ok := b.schemas[name]; !ok {
+		values := enum.Values()
+		names := make([]any, 0, values.Len())
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		schema := typeSchema("string", "")
+		schema.set("enum", names)
+		b.schemas[name] = schema
+	}
+	return schemaRef(enum.FullName())
+}
+
+// yamlMap is a YAML mapping that keeps its keys in insertion order, so that
+// the generated documents are deterministic.
+type yamlMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func newYAMLMap() *yamlMap {
+	return &yamlMap{values: make(map[string]any)}
+}
+
+func (m *yamlMap) set(key string, value any) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *yamlMap) get(key string) (any, bool) {
+	value, ok := m.values[key]
+	return value, ok
+}
+
+// writeYAML writes v, a *yamlMap, []any or scalar, in YAML block style.
+func writeYAML(buf *bytes.Buffer, v any, indent int) {
+	prefix := strings.Repeat("  ", indent)
+	switch v := v.(type) {
+	case *yamlMap:
+		for _, key := range v.keys {
+			value := v.values[key]
+			buf.WriteString(prefix + yamlKey(key) + ":")
+			if isYAMLCollection(value) {
+				buf.WriteString("\n")
+				writeYAML(buf, value, indent+1)
+			} else {
+				buf.WriteString(" " + yamlScalar(value) + "\n")
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if m, ok := item.(*yamlMap); ok && len(m.keys) != 0 {
+				// The first key goes on the dash line, the others are aligned with it.
+				var nested bytes.Buffer
+				writeYAML(&nested, m, indent+1)
+				buf.WriteString(prefix + "- " + strings.TrimPrefix(nested.String(), prefix+"  "))
+				continue
+			}
+			buf.WriteString(prefix + "- " + yamlScalar(item) + "\n")
+		}
+	}
+}
+
+func isYAMLCollection(v any) bool {
+	switch v := v.(type) {
+	case *yamlMap:
+		return len(v.keys) != 0
+	case []any:
+		return len(v) != 0
+	}
+	return false
+}
+
+func yamlScalar(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case *yamlMap:
+		return "{}"
+	case []any:
+		return "[]"
+	}
+	panic(fmt.Sprintf("unsupported YAML value %T", v))
+}
+
+// yamlKey quotes keys that are not plain identifiers, such as paths and
+// status codes.
+func yamlKey(key string) string {
+	if key == "" || key[0] < 'A' || (key[0] > 'Z' && key[0] < 'a' && key[0] != '_') || key[0] > 'z' {
+		return strconv.Quote(key)
+	}
+	for _, c := range key {
+		if !(c == '_' || c == '-' || c == '.' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z') {
+			return strconv.Quote(key)
+		}
+	}
+	return key
+}
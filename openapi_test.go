@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asjard/genproto/annotations"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+func TestOpenAPIGolden(t *testing.T) {
+	file := testFile("user.proto", "api.v1",
+		[]*descriptorpb.DescriptorProto{
+			testMessage("User",
+				stringField("id"),
+				stringField("name"),
+				messageField("create_time", ".google.protobuf.Timestamp"),
+				repeatedField(stringField("labels")),
+			),
+			testMessage("GetUserRequest", stringField("id")),
+			testMessage("CreateUserRequest", messageField("user", "User")),
+		},
+		testService("UserService",
+			testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")),
+			testMethod("CreateUser", "CreateUserRequest", "User", httpPost("/users", "user")),
+			testMethod("UpdateUser", "CreateUserRequest", "User", &annotations.Http{
+				Pattern: &annotations.Http_Patch{Patch: "/users/{user.id}"},
+				Body:    "user",
+			}),
+			testMethod("DeleteUser", "GetUserRequest", ".google.protobuf.Empty", httpDelete("/users/{id}")),
+			testMethod("Ping", ".google.protobuf.Empty", ".google.protobuf.Empty", httpGet("/ping")),
+		),
+	)
+	file.Dependency = append(file.Dependency, "google/protobuf/empty.proto", "google/protobuf/timestamp.proto")
+	locateMethod(file, 0, 0, 10, " Gets a user. Fails if it doesn't exist.\n")
+
+	got := generatedContent(t, runPlugin(t, "openapi_out=true", file), "example.com/api/user.openapi.yaml")
+	golden := filepath.Join("testdata", "user.openapi.yaml")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("OpenAPI document differs from %s, rerun with -update after checking:\n%s", golden, got)
+	}
+}
@@ -19,8 +19,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -32,9 +37,12 @@ import (
 )
 
 const (
-	contextPackage = protogen.GoImportPath("context")
-	restPackage    = protogen.GoImportPath("github.com/asjard/asjard/pkg/server/rest")
-	serverPackage  = protogen.GoImportPath("github.com/asjard/asjard/core/server")
+	contextPackage   = protogen.GoImportPath("context")
+	fmtPackage       = protogen.GoImportPath("fmt")
+	restPackage      = protogen.GoImportPath("github.com/asjard/asjard/pkg/server/rest")
+	serverPackage    = protogen.GoImportPath("github.com/asjard/asjard/core/server")
+	tracePackage     = protogen.GoImportPath("go.opentelemetry.io/otel/trace")
+	attributePackage = protogen.GoImportPath("go.opentelemetry.io/otel/attribute")
 	// restPackage    = protogen.GoImportPath("google.golang.org/grpc")
 	// codesPackage   = protogen.GoImportPath("google.golang.org/grpc/codes")
 	// statusPackage  = protogen.GoImportPath("google.golang.org/grpc/status")
@@ -81,12 +89,46 @@ func (serviceGenerateHelper) generateServerFunctions(gen *protogen.Plugin, file
 	// Server handler implementations.
 	handlerNames := make([]string, 0, len(service.Methods))
 	for _, method := range service.Methods {
-		hname := genServerMethod(gen, file, g, method, serverType, func(hname string) string {
+		hnameFuncNameFormatter := func(hname string) string {
 			return hname
-		})
+		}
+		var hname string
+		switch {
+		case !servedOverRest(method):
+			// Keeps handlerNames indexed like service.Methods.
+			if len(methodBindings(method)) != 0 {
+				reason := "client and bidirectional streaming are not supported over rest"
+				if !method.Desc.IsStreamingClient() {
+					reason = "server streaming needs server_streams=true"
+				}
+				warnf("%s: %s, method skipped", fullMethodName(method), reason)
+			}
+		case method.Desc.IsStreamingServer():
+			hname = genServerStreamMethod(gen, file, g, method, serverType, hnameFuncNameFormatter)
+		default:
+			hname = genServerMethod(gen, file, g, method, serverType, hnameFuncNameFormatter)
+		}
 		handlerNames = append(handlerNames, hname)
 	}
-	genServiceDesc(file, g, serviceDescVar, serverType, service, handlerNames)
+	genServiceDesc(file, g, serviceDescVar, serverType, service, handlerNames, "")
+	if *descSelfcheck {
+		genServiceDescSelfcheck(g, serviceDescVar)
+	}
+	if *versionGrouping {
+		for _, version := range serviceVersions(service) {
+			versionDescVar := versionServiceDescVar(service, version)
+			genServiceDesc(file, g, versionDescVar, serverType, service, handlerNames, version)
+			if *descSelfcheck {
+				genServiceDescSelfcheck(g, versionDescVar)
+			}
+		}
+	}
+	if *embedRoutesJSON {
+		genRoutesJSON(gen, g, service)
+	}
+	if *serverInfoRegistry {
+		genServerInfoRegistry(g, service)
+	}
 }
 
 func (serviceGenerateHelper) formatHandlerFuncName(service *protogen.Service, hname string) string {
@@ -101,19 +143,49 @@ const fileDescriptorProtoPackageFieldNumber = 2
 // FileDescriptorProto.syntax field number
 const fileDescriptorProtoSyntaxFieldNumber = 12
 
+// fileHasBindings reports whether any method of the file's services is
+// exposed on an HTTP route. Files without one get no generated file rather
+// than a stub with empty descriptors.
+func fileHasBindings(file *protogen.File) bool {
+	for _, service := range file.Services {
+		for _, method := range service.Methods {
+			if servedOverRest(method) && len(methodBindings(method)) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // generateFile generates a _grpc.pb.go file containing gRPC service definitions.
+//
+// Imports must only be introduced through GoIdent values, never written by
+// hand. protogen then emits a single import block sorted by path and prints
+// the file with gofmt's settings, so the output stays gofmt-clean and stable
+// whichever options add conditional imports.
 func generateFile(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
-	if len(file.Services) == 0 {
+	if !fileHasBindings(file) {
 		return nil
 	}
-	filename := file.GeneratedFilenamePrefix + "_rest.pb.go"
-	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+	prefix := file.GeneratedFilenamePrefix
+	importPath := file.GoImportPath
+	packageName := file.GoPackageName
+	if *packageSuffix != "" {
+		prefix = path.Join(path.Dir(prefix), *packageSuffix, path.Base(prefix))
+		importPath = protogen.GoImportPath(path.Join(string(importPath), *packageSuffix))
+		packageName = protogen.GoPackageName(*packageSuffix)
+	}
+	filename := prefix + *filenameSuffix
+	g := gen.NewGeneratedFile(filename, importPath)
 	// Attach all comments associated with the syntax field.
 	genLeadingComments(g, file.Desc.SourceLocations().ByPath(protoreflect.SourcePath{fileDescriptorProtoSyntaxFieldNumber}))
 	g.P("// Code generated by protoc-gen-go-grpc. DO NOT EDIT.")
 	g.P("// versions:")
 	g.P("// - protoc-gen-go-rest v", version)
 	g.P("// - protoc             ", protocVersion(gen))
+	if options := effectiveOptions(); options != "" {
+		g.P("// options: ", options)
+	}
 	if file.Proto.GetOptions().GetDeprecated() {
 		g.P("// ", file.Desc.Path(), " is a deprecated file.")
 	} else {
@@ -122,7 +194,7 @@ func generateFile(gen *protogen.Plugin, file *protogen.File) *protogen.Generated
 	g.P()
 	// Attach all comments associated with the package field.
 	genLeadingComments(g, file.Desc.SourceLocations().ByPath(protoreflect.SourcePath{fileDescriptorProtoPackageFieldNumber}))
-	g.P("package ", file.GoPackageName)
+	g.P("package ", packageName)
 	g.P()
 	generateFileContent(gen, file, g)
 	return g
@@ -145,6 +217,12 @@ func generateFileContent(gen *protogen.Plugin, file *protogen.File, g *protogen.
 	if len(file.Services) == 0 {
 		return
 	}
+	if *versionGrouping {
+		if err := checkServiceDescVars(file); err != nil {
+			gen.Error(err)
+			return
+		}
+	}
 	g.P()
 	for _, service := range file.Services {
 		genService(gen, file, g, service)
@@ -163,14 +241,79 @@ func genServiceComments(g *protogen.GeneratedFile, service *protogen.Service) {
 }
 
 func genService(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedFile, service *protogen.Service) {
+	if err := checkMethodGoNames(service); err != nil {
+		gen.Error(err)
+		return
+	}
+	for _, method := range service.Methods {
+		if err := checkMethodBindings(method); err != nil {
+			gen.Error(err)
+			return
+		}
+	}
 	// Full methods constants.
 	helper.genFullMethods(g, service)
 
-	serverType := service.GoName + "Server"
+	// Qualified so that the code still compiles when emitted into package_suffix.
+	serverType := g.QualifiedGoIdent(file.GoImportPath.Ident(service.GoName + "Server"))
 	serviceDescVar := service.GoName + "RestServiceDesc"
 	helper.generateServerFunctions(gen, file, g, service, serverType, serviceDescVar)
 }
 
+// checkServiceDescVars rejects files where a per-version ServiceDesc of a
+// service would take the name of another descriptor, e.g. the v1 one of Foo
+// and the one of a FooV1 service.
+func checkServiceDescVars(file *protogen.File) error {
+	owners := make(map[string]string)
+	for _, service := range file.Services {
+		owners[service.GoName+"RestServiceDesc"] = fmt.Sprintf("the ServiceDesc of %s", service.Desc.FullName())
+	}
+	for _, service := range file.Services {
+		for _, version := range serviceVersions(service) {
+			descVar := versionServiceDescVar(service, version)
+			owner := fmt.Sprintf("the %s ServiceDesc of %s", version, service.Desc.FullName())
+			if other, ok := owners[descVar]; ok {
+				return fmt.Errorf("version_grouping: %s and %s are both named %s", other, owner, descVar)
+			}
+			owners[descVar] = owner
+		}
+	}
+	return nil
+}
+
+// versionServiceDescVar names the ServiceDesc holding the version routes of
+// service under version_grouping.
+func versionServiceDescVar(service *protogen.Service, version string) string {
+	return service.GoName + strings.ToUpper(version[:1]) + version[1:] + "RestServiceDesc"
+}
+
+// checkMethodGoNames rejects services with two methods mapping to the same Go
+// name, such as get_user and GetUser, which would otherwise produce duplicate
+// handler symbols.
+func checkMethodGoNames(service *protogen.Service) error {
+	byGoName := make(map[string]*protogen.Method, len(service.Methods))
+	for _, method := range service.Methods {
+		other, ok := byGoName[method.GoName]
+		if !ok {
+			byGoName[method.GoName] = method
+			continue
+		}
+		return fmt.Errorf("%s: methods %s (%s) and %s (%s) both map to Go name %s",
+			service.Desc.FullName(),
+			other.Desc.Name(), methodPosition(other),
+			method.Desc.Name(), methodPosition(method),
+			method.GoName)
+	}
+	return nil
+}
+
+func methodPosition(method *protogen.Method) string {
+	if position, ok := sourcePosition(method.Desc); ok {
+		return position
+	}
+	return method.Desc.ParentFile().Path()
+}
+
 func clientSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
 	s := method.GoName + "(ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context"))
 	if !method.Desc.IsStreamingClient() {
@@ -316,71 +459,65 @@ func serverSignature(g *protogen.GeneratedFile, method *protogen.Method) string
 	return method.GoName + "(" + strings.Join(reqArgs, ", ") + ") " + ret
 }
 
-func genServiceDesc(_ *protogen.File, g *protogen.GeneratedFile, serviceDescVar string, serverType string, service *protogen.Service, handlerNames []string) {
+// genServiceDesc emits the ServiceDesc of the service. With a version, as
+// under version_grouping, it only holds the bindings of that version;
+// without, it holds them all.
+func genServiceDesc(_ *protogen.File, g *protogen.GeneratedFile, serviceDescVar string, serverType string, service *protogen.Service, handlerNames []string, version string) {
 	// Service descriptor.
-	g.P("// ", serviceDescVar, " is the ", restPackage.Ident("ServiceDesc"), " for ", service.GoName, " service.")
+	if version == "" {
+		g.P("// ", serviceDescVar, " is the ", restPackage.Ident("ServiceDesc"), " for ", service.GoName, " service.")
+	} else {
+		g.P("// ", serviceDescVar, " is the ", restPackage.Ident("ServiceDesc"), " for the ", version, " routes of ", service.GoName, " service.")
+	}
 	g.P("// It's only intended for direct use with ", restPackage.Ident("AddHandler"), ",")
 	g.P("// and not to be introspected or modified (even as a copy)")
 	g.P("var ", serviceDescVar, " = ", restPackage.Ident("ServiceDesc"), " {")
 	g.P("ServiceName: ", strconv.Quote(string(service.Desc.FullName())), ",")
 	g.P("HandlerType: (*", serverType, ")(nil),")
+	g.P("HandlerTypeName: ", strconv.Quote(service.GoName+"Server"), ",")
 	g.P("Methods: []", restPackage.Ident("MethodDesc"), "{")
 	for i, method := range service.Methods {
-		if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+		if !servedOverRest(method) {
 			continue
 		}
-		var methodDesc []byte
-		commentLines := strings.Split(strings.TrimSuffix(method.Comments.Leading.String(), "\n"), "\n")
-		commentLinesLen := len(commentLines)
-		for index, line := range commentLines {
-			methodDesc = append(methodDesc, strings.TrimPrefix(line, "// ")...)
-			if index == commentLinesLen-1 {
-				methodDesc = append(methodDesc, "."...)
-			} else {
-				methodDesc = append(methodDesc, ","...)
+		methodDesc := methodDescription(method)
+		for _, binding := range methodBindings(method) {
+			if version != "" && binding.version != version {
+				continue
 			}
-		}
-		httpOptions, ok := proto.GetExtension(method.Desc.Options(), annotations.E_Http).([]*annotations.Http)
-		if ok {
-			for _, httpOption := range httpOptions {
-				g.P("{")
-				g.P("MethodName: ", strconv.Quote(string(method.Desc.Name())), ",")
-				g.P("Desc: ", strconv.Quote(string(methodDesc)), ",")
-				var optionMethod, optionPath string
-				switch httpOption.GetPattern().(type) {
-				case *annotations.Http_Get:
-					optionMethod = http.MethodGet
-					optionPath = httpOption.GetGet()
-				case *annotations.Http_Put:
-					optionMethod = http.MethodPut
-					optionPath = httpOption.GetPut()
-				case *annotations.Http_Post:
-					optionMethod = http.MethodPost
-					optionPath = httpOption.GetPost()
-				case *annotations.Http_Delete:
-					optionMethod = http.MethodDelete
-					optionPath = httpOption.GetDelete()
-				case *annotations.Http_Patch:
-					optionMethod = http.MethodPatch
-					optionPath = httpOption.GetPatch()
-				case *annotations.Http_Head:
-					optionMethod = http.MethodHead
-					optionPath = httpOption.GetHead()
-				}
-				g.P("Method:", strconv.Quote(optionMethod), ",")
-				// 根据package名称解析
-				// api.v1.xxx
-				// 第一部分为接口类型
-				// 第二部分为接口版本
-				serviceFullNameList := strings.Split(string(service.Desc.FullName()), ".")
-				if len(serviceFullNameList) < 2 {
-					panic("invalid package name")
-				}
-				fullPath := "/" + serviceFullNameList[0] + "/" + serviceFullNameList[1] + "/" + strings.TrimPrefix(optionPath, "/")
-				g.P("Path:", strconv.Quote(fullPath), ",")
-				g.P("Handler: ", handlerNames[i], ",")
-				g.P("},")
+			genSourceRef(g, method.Desc)
+			g.P("{")
+			g.P("MethodName: ", strconv.Quote(string(method.Desc.Name())), ",")
+			g.P("Desc: ", strconv.Quote(methodDesc), ",")
+			g.P("Method: ", httpMethodValue(g, binding.httpMethod), ",")
+			g.P("Path:", strconv.Quote(binding.path), ",")
+			if binding.body != "" {
+				g.P("Body: ", strconv.Quote(binding.body), ",")
+			}
+			if binding.responseBody != "" {
+				g.P("ResponseBody: ", strconv.Quote(binding.responseBody), ",")
+			}
+			if len(binding.pathParams) != 0 {
+				g.P("PathParams: []string{", joinQuoted(binding.pathParams), "},")
+			}
+			if method.Desc.IsStreamingServer() {
+				g.P("ServerStreams: true,")
+			}
+			if methodDeprecated(method) {
+				g.P("Deprecated: true,")
 			}
+			// Left out when it's the runtime default of 200.
+			if status := defaultSuccessStatus(binding.httpMethod); status != http.StatusOK {
+				g.P("SuccessStatus: ", status, ",")
+			}
+			if *maxQueryBytes != 0 {
+				g.P("MaxQueryBytes: ", *maxQueryBytes, ",")
+			}
+			if consumes := bindingConsumes(binding); len(consumes) != 0 {
+				g.P("Consumes: []string{", joinQuoted(consumes), "},")
+			}
+			genBindingHandler(g, binding, handlerNames[i])
+			g.P("},")
 		}
 	}
 	g.P("},")
@@ -388,6 +525,467 @@ func genServiceDesc(_ *protogen.File, g *protogen.GeneratedFile, serviceDescVar
 	g.P()
 }
 
+// restBinding is a single HTTP route a method is exposed on.
+type restBinding struct {
+	method     *protogen.Method
+	httpMethod string
+	// path is the full route path, including the package prefix.
+	path string
+	// body is the input field bound from the request body, "*" for the
+	// whole message and empty when the body is not bound.
+	body string
+	// responseBody is the output field written as the response body, empty
+	// for the whole message.
+	responseBody string
+	// pathParams are the input field paths bound from the path variables,
+	// in template order, such as "shelf_id" or "book.id".
+	pathParams []string
+	// version is the leading /v{n} segment of the annotated path, if any.
+	version string
+}
+
+// methodBindings returns the HTTP routes declared by the method's
+// annotations, in declaration order, each followed by its
+// additional_bindings. Explicit annotations always win over the routes
+// inferred under lro_routes.
+func methodBindings(method *protogen.Method) []*restBinding {
+	httpOptions, _ := proto.GetExtension(method.Desc.Options(), annotations.E_Http).([]*annotations.Http)
+	if len(httpOptions) == 0 {
+		if *lroRoutes {
+			if binding := lroBinding(method); binding != nil {
+				return []*restBinding{binding}
+			}
+		}
+		return nil
+	}
+	bindings := make([]*restBinding, 0, len(httpOptions))
+	for _, httpOption := range httpOptions {
+		bindings = append(bindings, newRestBinding(method, httpOption))
+		// Additional bindings are a single level deep, checkMethodBindings
+		// rejects nested ones.
+		for _, additionalBinding := range httpOption.GetAdditionalBindings() {
+			bindings = append(bindings, newRestBinding(method, additionalBinding))
+		}
+	}
+	return bindings
+}
+
+func newRestBinding(method *protogen.Method, httpOption *annotations.Http) *restBinding {
+	optionMethod, optionPath := httpPattern(httpOption)
+	body := httpOption.GetBody()
+	if body == "" && *implicitBody && isMutatingMethod(optionMethod) {
+		body = "*"
+	}
+	_, pathParams := parsePathTemplate(optionPath)
+	return &restBinding{
+		method:       method,
+		httpMethod:   optionMethod,
+		path:         restFullPath(method.Parent, optionPath),
+		pathParams:   pathParams,
+		body:         body,
+		responseBody: httpOption.GetResponseBody(),
+		version:      pathVersion(optionPath),
+	}
+}
+
+// httpPattern returns the HTTP method and the annotated path of httpOption,
+// both empty if it sets no pattern.
+func httpPattern(httpOption *annotations.Http) (optionMethod, optionPath string) {
+	switch httpOption.GetPattern().(type) {
+	case *annotations.Http_Get:
+		optionMethod = http.MethodGet
+		optionPath = httpOption.GetGet()
+	case *annotations.Http_Put:
+		optionMethod = http.MethodPut
+		optionPath = httpOption.GetPut()
+	case *annotations.Http_Post:
+		optionMethod = http.MethodPost
+		optionPath = httpOption.GetPost()
+	case *annotations.Http_Delete:
+		optionMethod = http.MethodDelete
+		optionPath = httpOption.GetDelete()
+	case *annotations.Http_Patch:
+		optionMethod = http.MethodPatch
+		optionPath = httpOption.GetPatch()
+	case *annotations.Http_Head:
+		optionMethod = http.MethodHead
+		optionPath = httpOption.GetHead()
+	case *annotations.Http_Custom:
+		optionMethod = httpOption.GetCustom().GetKind()
+		optionPath = httpOption.GetCustom().GetPath()
+	}
+	return optionMethod, optionPath
+}
+
+// checkMethodBindings validates the method's http annotations before any
+// code is generated from them.
+func checkMethodBindings(method *protogen.Method) error {
+	httpOptions, _ := proto.GetExtension(method.Desc.Options(), annotations.E_Http).([]*annotations.Http)
+	for _, httpOption := range httpOptions {
+		if err := checkHTTPOption(method, httpOption); err != nil {
+			return err
+		}
+		for _, additionalBinding := range httpOption.GetAdditionalBindings() {
+			if len(additionalBinding.GetAdditionalBindings()) != 0 {
+				return fmt.Errorf("%s (%s): additional_bindings must not be nested",
+					fullMethodName(method), methodPosition(method))
+			}
+			if err := checkHTTPOption(method, additionalBinding); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkHTTPOption(method *protogen.Method, httpOption *annotations.Http) error {
+	if httpOption.GetPattern() == nil {
+		return fmt.Errorf("%s (%s): http annotation sets no pattern",
+			fullMethodName(method), methodPosition(method))
+	}
+	if custom, ok := httpOption.GetPattern().(*annotations.Http_Custom); ok && custom.Custom.GetKind() == "" {
+		return fmt.Errorf("%s (%s): custom http pattern for path %q has an empty kind",
+			fullMethodName(method), methodPosition(method), custom.Custom.GetPath())
+	}
+	if optionMethod, optionPath := httpPattern(httpOption); optionPath == "" {
+		return fmt.Errorf("%s (%s): http %s pattern has an empty path",
+			fullMethodName(method), methodPosition(method), optionMethod)
+	}
+	for _, pathParam := range newRestBinding(method, httpOption).pathParams {
+		field, err := resolveFieldPath(method.Input, pathParam)
+		if err == nil && (field.Desc.IsList() || field.Desc.IsMap() || field.Message != nil) {
+			err = fmt.Errorf("field %s is not a singular scalar", field.Desc.Name())
+		}
+		if err != nil {
+			return fmt.Errorf("%s (%s): path variable {%s}: %w",
+				fullMethodName(method), methodPosition(method), pathParam, err)
+		}
+	}
+	if body := httpOption.GetBody(); body != "" && body != "*" && method.Input.Desc.Fields().ByName(protoreflect.Name(body)) == nil {
+		return fmt.Errorf("%s (%s): body %q is not a field of %s",
+			fullMethodName(method), methodPosition(method), body, method.Input.Desc.FullName())
+	}
+	if err := checkFormBody(newRestBinding(method, httpOption)); err != nil {
+		return err
+	}
+	if responseBody := httpOption.GetResponseBody(); responseBody != "" && method.Output.Desc.Fields().ByName(protoreflect.Name(responseBody)) == nil {
+		return fmt.Errorf("%s (%s): response_body %q is not a field of %s",
+			fullMethodName(method), methodPosition(method), responseBody, method.Output.Desc.FullName())
+	}
+	return nil
+}
+
+// parsePathTemplate returns the path template with the segment patterns of
+// its variables dropped ("{name=shelves/*}" becomes "{name}") and the
+// variable names in order.
+func parsePathTemplate(template string) (string, []string) {
+	var (
+		out  strings.Builder
+		vars []string
+	)
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		name, _, _ := strings.Cut(template[start+1:end], "=")
+		vars = append(vars, name)
+		out.WriteString(template[:start])
+		out.WriteString("{" + name + "}")
+		template = template[end+1:]
+	}
+	out.WriteString(template)
+	return out.String(), vars
+}
+
+// resolveFieldPath resolves a dotted field path such as "book.id" against
+// message. Every segment but the last must be a singular message field.
+func resolveFieldPath(message *protogen.Message, fieldPath string) (*protogen.Field, error) {
+	var field *protogen.Field
+	for _, name := range strings.Split(fieldPath, ".") {
+		if field != nil {
+			if field.Message == nil || field.Desc.IsList() || field.Desc.IsMap() {
+				return nil, fmt.Errorf("field %s is not a singular message", field.Desc.Name())
+			}
+			message = field.Message
+		}
+		field = nil
+		for _, f := range message.Fields {
+			if string(f.Desc.Name()) == name {
+				field = f
+				break
+			}
+		}
+		if field == nil {
+			return nil, fmt.Errorf("%s has no field %s", message.Desc.FullName(), name)
+		}
+	}
+	return field, nil
+}
+
+// bindingConsumes returns the request media types recorded in the binding's
+// MethodDesc besides the runtime default.
+func bindingConsumes(binding *restBinding) []string {
+	var consumes []string
+	if *mergePatch && binding.httpMethod == http.MethodPatch {
+		consumes = append(consumes, mergePatchContentType)
+	}
+	if *formBody && binding.httpMethod == http.MethodPost && binding.body != "" {
+		consumes = append(consumes, formContentType)
+	}
+	return consumes
+}
+
+// checkFormBody rejects form_body bindings whose body binds a message or map
+// field, as form values are flat key/value pairs. Only the fields read from
+// the body count: all those not bound from the path for "*", the fields of
+// the named body field otherwise.
+func checkFormBody(binding *restBinding) error {
+	if !*formBody || binding.httpMethod != http.MethodPost || binding.body == "" {
+		return nil
+	}
+	message := binding.method.Input
+	var fields []*protogen.Field
+	if binding.body == "*" {
+		for _, field := range message.Fields {
+			if !slices.Contains(binding.pathParams, string(field.Desc.Name())) {
+				fields = append(fields, field)
+			}
+		}
+	} else {
+		for _, field := range message.Fields {
+			if string(field.Desc.Name()) == binding.body {
+				fields = []*protogen.Field{field}
+			}
+		}
+		if len(fields) == 1 && fields[0].Message != nil && !fields[0].Desc.IsList() && !fields[0].Desc.IsMap() {
+			message = fields[0].Message
+			fields = message.Fields
+		}
+	}
+	for _, field := range fields {
+		if field.Message != nil {
+			return fmt.Errorf("%s (%s): form_body: field %s of %s is a message and can't be form-encoded",
+				fullMethodName(binding.method), methodPosition(binding.method), field.Desc.Name(), message.Desc.FullName())
+		}
+	}
+	return nil
+}
+
+func joinQuoted(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, strconv.Quote(value))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// pathVersion returns the leading version segment of an annotated path such
+// as "v2" for "/v2/users/{id}", or "" if the path doesn't start with one.
+func pathVersion(optionPath string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(optionPath, "/"), "/")
+	if len(segment) < 2 || segment[0] != 'v' {
+		return ""
+	}
+	for _, c := range segment[1:] {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	return segment
+}
+
+// serviceVersions returns the path versions used by the service's bindings,
+// in order of first appearance.
+func serviceVersions(service *protogen.Service) []string {
+	var versions []string
+	seen := make(map[string]bool)
+	for _, method := range service.Methods {
+		if !servedOverRest(method) {
+			continue
+		}
+		for _, binding := range methodBindings(method) {
+			if binding.version != "" && !seen[binding.version] {
+				seen[binding.version] = true
+				versions = append(versions, binding.version)
+			}
+		}
+	}
+	return versions
+}
+
+// restMethodConstants maps the standard HTTP methods to the typed
+// rest.HTTPMethod constants of the runtime.
+var restMethodConstants = map[string]string{
+	http.MethodGet:    "MethodGet",
+	http.MethodPut:    "MethodPut",
+	http.MethodPost:   "MethodPost",
+	http.MethodDelete: "MethodDelete",
+	http.MethodPatch:  "MethodPatch",
+	http.MethodHead:   "MethodHead",
+}
+
+// httpMethodValue returns the MethodDesc.Method expression for method: the
+// typed runtime constant for standard verbs and a string literal otherwise.
+func httpMethodValue(g *protogen.GeneratedFile, method string) string {
+	if constant, ok := restMethodConstants[method]; ok {
+		return g.QualifiedGoIdent(restPackage.Ident(constant))
+	}
+	return strconv.Quote(method)
+}
+
+// isMutatingMethod reports whether requests with the HTTP method carry a body.
+func isMutatingMethod(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
+
+// fullMethodName returns the name interceptors see in UnaryServerInfo.FullMethod.
+func fullMethodName(method *protogen.Method) string {
+	return string(method.Parent.Desc.FullName()) + "." + string(method.Desc.Name())
+}
+
+// restFullPath prefixes the annotated path with the API type and version
+// taken from the proto package. Services without a package keep the
+// annotated path as is.
+func restFullPath(service *protogen.Service, optionPath string) string {
+	fullPath := "/" + strings.TrimPrefix(optionPath, "/")
+	if service.Desc.ParentFile().Package() == "" {
+		return fullPath
+	}
+	// 根据package名称解析
+	// api.v1.xxx
+	// 第一部分为接口类型
+	// 第二部分为接口版本
+	serviceFullNameList := strings.Split(string(service.Desc.FullName()), ".")
+	if len(serviceFullNameList) < 2 {
+		panic("invalid package name")
+	}
+	return "/" + serviceFullNameList[0] + "/" + serviceFullNameList[1] + fullPath
+}
+
+// defaultSuccessStatus returns the status code written for a successful call
+// bound to the given HTTP method: 201 for POST, 204 for DELETE and 200 otherwise.
+func defaultSuccessStatus(method string) int {
+	switch method {
+	case http.MethodPost:
+		return http.StatusCreated
+	case http.MethodDelete:
+		return http.StatusNoContent
+	default:
+		return http.StatusOK
+	}
+}
+
+// genServiceDescSelfcheck emits a check of the ServiceDesc that runs at package
+// init, so that a malformed descriptor fails at startup instead of at request time.
+func genServiceDescSelfcheck(g *protogen.GeneratedFile, serviceDescVar string) {
+	checkFunc := "check" + serviceDescVar
+	g.P("func init() {")
+	g.P("if err := ", checkFunc, "(); err != nil {")
+	g.P("panic(err)")
+	g.P("}")
+	g.P("}")
+	g.P()
+	g.P("// ", checkFunc, " verifies that every method of ", serviceDescVar, " has a method, a path and a handler.")
+	g.P("func ", checkFunc, "() error {")
+	g.P("for i, method := range ", serviceDescVar, ".Methods {")
+	g.P("switch {")
+	g.P(`case method.Method == "":`)
+	g.P("return ", fmtPackage.Ident("Errorf"), `("%s: method %d (%s) has no HTTP method", `, serviceDescVar, ".ServiceName, i, method.MethodName)")
+	g.P(`case method.Path == "":`)
+	g.P("return ", fmtPackage.Ident("Errorf"), `("%s: method %d (%s) has no path", `, serviceDescVar, ".ServiceName, i, method.MethodName)")
+	g.P("case method.Handler == nil:")
+	g.P("return ", fmtPackage.Ident("Errorf"), `("%s: method %d (%s) has no handler", `, serviceDescVar, ".ServiceName, i, method.MethodName)")
+	g.P("}")
+	g.P("}")
+	g.P("return nil")
+	g.P("}")
+	g.P()
+}
+
+// genRoutesJSON emits the service's routes as a JSON string, so that tools
+// reading the package can list them without the full OpenAPI document.
+func genRoutesJSON(gen *protogen.Plugin, g *protogen.GeneratedFile, service *protogen.Service) {
+	routes := serviceRoutes(service)
+	if routes == nil {
+		routes = []restRoute{}
+	}
+	data, err := json.MarshalIndent(routes, "", "  ")
+	if err != nil {
+		gen.Error(fmt.Errorf("marshal routes of %s: %w", service.Desc.FullName(), err))
+		return
+	}
+	literal := "`" + string(data) + "`"
+	if strings.Contains(string(data), "`") {
+		literal = strconv.Quote(string(data))
+	}
+	routesVar := service.GoName + "RestRoutesJSON"
+	g.P("// ", routesVar, " lists the routes of ", service.GoName, " service as JSON.")
+	g.P("var ", routesVar, " = ", literal)
+	g.P()
+}
+
+// genServerInfoRegistry emits the UnaryServerInfo the handlers pass to
+// interceptors, one entry per method with at least one route and without
+// the per-request Server, so that middleware can be configured up front.
+// Each entry also records whether the method is deprecated, which
+// UnaryServerInfo can't carry.
+func genServerInfoRegistry(g *protogen.GeneratedFile, service *protogen.Service) {
+	infoType := service.GoName + "RestServerInfo"
+	registryVar := service.GoName + "RestServerInfos"
+	g.P("// ", infoType, " is the static data of a ", service.GoName, " method exposed over rest.")
+	g.P("type ", infoType, " struct {")
+	g.P(serverPackage.Ident("UnaryServerInfo"))
+	g.P("// Deprecated reports whether the method is marked deprecated in its proto file.")
+	g.P("Deprecated bool")
+	g.P("}")
+	g.P()
+	g.P("// ", registryVar, " lists the static ", infoType, " of every ", service.GoName, " method exposed over rest.")
+	g.P("var ", registryVar, " = []", infoType, "{")
+	for _, method := range service.Methods {
+		if !servedOverRest(method) || len(methodBindings(method)) == 0 {
+			continue
+		}
+		g.P("{")
+		g.P("UnaryServerInfo: ", serverPackage.Ident("UnaryServerInfo"), "{")
+		g.P("FullMethod: ", strconv.Quote(fullMethodName(method)), ",")
+		g.P("Protocol: ", restPackage.Ident("Protocol"), ",")
+		g.P("},")
+		if methodDeprecated(method) {
+			g.P("Deprecated: true,")
+		}
+		g.P("},")
+	}
+	g.P("}")
+	g.P()
+}
+
+// genBindingHandler emits the Handler field of a MethodDesc. With tracing
+// enabled the handler is wrapped so that the span carries the low-cardinality
+// route template instead of the concrete request path; only OpenTelemetry
+// spans are supported. With handler_vars the variable is looked up on every
+// call, so reassigning it after package initialization still takes effect.
+func genBindingHandler(g *protogen.GeneratedFile, binding *restBinding, handlerName string) {
+	if !*tracing && !*handlerVars {
+		g.P("Handler: ", handlerName, ",")
+		return
+	}
+	g.P("Handler: func(ctx *", restPackage.Ident("Context"), ", srv any, interceptor ", serverPackage.Ident("UnaryServerInterceptor"), ") (any, error) {")
+	if *tracing {
+		g.P(tracePackage.Ident("SpanFromContext"), "(ctx).SetAttributes(")
+		g.P(attributePackage.Ident("String"), `("http.route", `, strconv.Quote(binding.path), "),")
+		g.P(attributePackage.Ident("String"), `("rpc.method", `, strconv.Quote(fullMethodName(binding.method)), "),")
+		g.P(")")
+	}
+	g.P("return ", handlerName, "(ctx, srv, interceptor)")
+	g.P("},")
+}
+
 func serverStreamInterface(g *protogen.GeneratedFile, method *protogen.Method) string {
 	typeParam := g.QualifiedGoIdent(method.Input.GoIdent) + ", " + g.QualifiedGoIdent(method.Output.GoIdent)
 	if method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer() {
@@ -403,14 +1001,15 @@ func genServerMethod(gen *protogen.Plugin, file *protogen.File, g *protogen.Gene
 	service := method.Parent
 	hname := fmt.Sprintf("_%s_%s_RestHandler", service.GoName, method.GoName)
 
-	g.P("func ", hnameFuncNameFormatter(hname), "(ctx *", restPackage.Ident("Context"), ", srv any, interceptor ", serverPackage.Ident("UnaryServerInterceptor"), ") (any, error) {")
+	genHandlerDoc(g, method)
+	genHandlerSignature(g, hnameFuncNameFormatter(hname))
 	g.P("in := new(", method.Input.GoIdent, ")")
 	g.P("if interceptor == nil {")
 	g.P("return srv.(", serverType, ").", method.GoName, "(ctx, in)")
 	g.P("}")
 	g.P("info := &", serverPackage.Ident("UnaryServerInfo"), "{")
 	g.P("Server: srv,")
-	g.P("FullMethod: \"", service.Desc.FullName(), ".", method.Desc.Name(), "\",")
+	g.P("FullMethod: ", strconv.Quote(fullMethodName(method)), ",")
 	g.P("Protocol: ", restPackage.Ident("Protocol"), ",")
 	g.P("}")
 	g.P("handler := func(ctx ", contextPackage.Ident("Context"), ",req any)(any, error) {")
@@ -421,7 +1020,140 @@ func genServerMethod(gen *protogen.Plugin, file *protogen.File, g *protogen.Gene
 	return hname
 }
 
+// genHandlerSignature opens the declaration of a handler, as a function or,
+// under handler_vars, as a function variable.
+func genHandlerSignature(g *protogen.GeneratedFile, hname string) {
+	signature := "(ctx *" + g.QualifiedGoIdent(restPackage.Ident("Context")) + ", srv any, interceptor " + g.QualifiedGoIdent(serverPackage.Ident("UnaryServerInterceptor")) + ") (any, error) {"
+	if *handlerVars {
+		g.P("var ", hname, " = func", signature)
+	} else {
+		g.P("func ", hname, signature)
+	}
+}
+
+// genServerStreamMethod generates the handler of a server-streaming method,
+// whose messages are sent to the client as Server-Sent Events. Interceptors
+// see the method as unary: they get the same UnaryServerInfo as unary methods
+// and run once around the whole stream, whose handler returns a nil response.
+//
+// The event framing is left to the runtime, which server_streams requires to
+// provide:
+//
+//	// ServerStream is a grpc.ServerStream over the response of a request.
+//	// SendMsg writes m as one "data:" event and flushes it; RecvMsg
+//	// fails, the request body having been decoded already.
+//	type ServerStream interface {
+//		grpc.ServerStream
+//	}
+//
+//	// NewServerStream starts the text/event-stream response of ctx.
+//	func NewServerStream(ctx *Context) ServerStream
+//
+// The generated adapter embeds the ServerStream and adds Send, so that it
+// implements the <Service>_<Method>Server interface of protoc-gen-go-grpc,
+// which the generated code asserts.
+func genServerStreamMethod(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedFile, method *protogen.Method, serverType string, hnameFuncNameFormatter func(string) string) string {
+	service := method.Parent
+	hname := fmt.Sprintf("_%s_%s_RestHandler", service.GoName, method.GoName)
+	streamType := fmt.Sprintf("_%s_%s_RestServerStream", service.GoName, method.GoName)
+	streamServer := protogen.GoIdent{
+		GoName:       service.GoName + "_" + method.GoName + "Server",
+		GoImportPath: file.GoImportPath,
+	}
+
+	g.P("// ", streamType, " adapts the rest Server-Sent Events stream to the ", method.GoName, " stream of ", serverType, ".")
+	g.P("type ", streamType, " struct {")
+	g.P(restPackage.Ident("ServerStream"))
+	g.P("}")
+	g.P()
+	g.P("var _ ", streamServer, " = (*", streamType, ")(nil)")
+	g.P()
+	g.P("func (x *", streamType, ") Send(m *", method.Output.GoIdent, ") error {")
+	g.P("return x.ServerStream.SendMsg(m)")
+	g.P("}")
+	g.P()
+
+	genHandlerDoc(g, method)
+	genHandlerSignature(g, hnameFuncNameFormatter(hname))
+	g.P("in := new(", method.Input.GoIdent, ")")
+	g.P("stream := &", streamType, "{ServerStream: ", restPackage.Ident("NewServerStream"), "(ctx)}")
+	g.P("if interceptor == nil {")
+	g.P("return nil, srv.(", serverType, ").", method.GoName, "(in, stream)")
+	g.P("}")
+	g.P("info := &", serverPackage.Ident("UnaryServerInfo"), "{")
+	g.P("Server: srv,")
+	g.P("FullMethod: ", strconv.Quote(fullMethodName(method)), ",")
+	g.P("Protocol: ", restPackage.Ident("Protocol"), ",")
+	g.P("}")
+	g.P("handler := func(ctx ", contextPackage.Ident("Context"), ",req any)(any, error) {")
+	g.P("return nil, srv.(", serverType, ").", method.GoName, "(in, stream)")
+	g.P("}")
+	g.P("return interceptor(ctx, in, info, handler)")
+	g.P("}")
+	return hname
+}
+
+// servedOverRest reports whether the method gets a handler: unary methods
+// do, server-streaming ones under server_streams, client and bidirectional
+// streaming ones never.
+func servedOverRest(method *protogen.Method) bool {
+	if method.Desc.IsStreamingClient() {
+		return false
+	}
+	return !method.Desc.IsStreamingServer() || *serverStreams
+}
+
+// warnings receives the warnings of the run, stderr unless a test captures
+// them.
+var warnings io.Writer = os.Stderr
+
+// warnf reports a problem that doesn't fail the run.
+func warnf(format string, args ...any) {
+	fmt.Fprintf(warnings, "protoc-gen-go-rest: WARNING: "+format+"\n", args...)
+}
+
+// genHandlerDoc emits the doc comment of a method's handler: its source
+// reference, then the deprecation notice in a paragraph of its own so that
+// go doc recognizes it.
+func genHandlerDoc(g *protogen.GeneratedFile, method *protogen.Method) {
+	ref := genSourceRef(g, method.Desc)
+	if !methodDeprecated(method) {
+		return
+	}
+	if ref {
+		g.P("//")
+	}
+	g.P(deprecationComment)
+}
+
+// genSourceRef emits a comment pointing at the proto line that defines desc,
+// if source_refs is set and the descriptor carries source information. It
+// reports whether it emitted one.
+func genSourceRef(g *protogen.GeneratedFile, desc protoreflect.Descriptor) bool {
+	if !*sourceRefs {
+		return false
+	}
+	position, ok := sourcePosition(desc)
+	if ok {
+		g.P("// defined at ", position)
+	}
+	return ok
+}
+
+// sourcePosition returns the "file.proto:line" that defines desc, if the
+// descriptor carries source information.
+func sourcePosition(desc protoreflect.Descriptor) (string, bool) {
+	loc := desc.ParentFile().SourceLocations().ByDescriptor(desc)
+	if loc.Path == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%d", desc.ParentFile().Path(), loc.StartLine+1), true
+}
+
 func genLeadingComments(g *protogen.GeneratedFile, loc protoreflect.SourceLocation) {
+	if *stripComments {
+		return
+	}
 	for _, s := range loc.LeadingDetachedComments {
 		g.P(protogen.Comments(s))
 		g.P()
@@ -432,6 +1164,20 @@ func genLeadingComments(g *protogen.GeneratedFile, loc protoreflect.SourceLocati
 	}
 }
 
+const (
+	// mergePatchContentType is the media type of RFC 7386 JSON Merge Patch bodies.
+	mergePatchContentType = "application/merge-patch+json"
+	// formContentType is the media type of HTML form submissions.
+	formContentType = "application/x-www-form-urlencoded"
+)
+
 const deprecationComment = "// Deprecated: Do not use."
 
+// methodDeprecated reports whether the method, or the file declaring it, is
+// marked deprecated.
+func methodDeprecated(method *protogen.Method) bool {
+	return method.Desc.ParentFile().Options().(*descriptorpb.FileOptions).GetDeprecated() ||
+		method.Desc.Options().(*descriptorpb.MethodOptions).GetDeprecated()
+}
+
 func unexport(s string) string { return strings.ToLower(s[:1]) + s[1:] }
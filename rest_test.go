@@ -0,0 +1,760 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/asjard/genproto/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const userOutput = "example.com/api/user_rest.pb.go"
+
+func TestPackagelessService(t *testing.T) {
+	file := testFile("greeter.proto", "",
+		[]*descriptorpb.DescriptorProto{
+			testMessage("HelloRequest", stringField("name")),
+			testMessage("HelloReply", stringField("message")),
+		},
+		testService("Greeter", testMethod("SayHello", "HelloRequest", "HelloReply", httpGet("/hello"))),
+	)
+	content := generatedContent(t, runPlugin(t, "", file), "example.com/api/greeter_rest.pb.go")
+	wantContains(t, content,
+		`ServiceName: "Greeter",`,
+		`FullMethod: "Greeter.SayHello",`,
+		`Path: "/hello",`,
+	)
+	wantNotContains(t, content, `"/Greeter`, `".Greeter`, `"//`)
+}
+
+// methodDescs returns the MethodDesc entries of the named ServiceDesc
+// variable, each starting at its MethodName.
+func methodDescs(t *testing.T, content, descVar string) []string {
+	t.Helper()
+	start := strings.Index(content, "var "+descVar+" = ")
+	if start < 0 {
+		t.Fatalf("no %s in generated code:\n%s", descVar, content)
+	}
+	desc := content[start:]
+	desc = desc[:strings.Index(desc, "\n}\n")]
+	var entries []string
+	for _, entry := range strings.Split(desc, "MethodName:")[1:] {
+		entries = append(entries, "MethodName:"+entry)
+	}
+	return entries
+}
+
+var (
+	methodNamePattern = regexp.MustCompile(`MethodName:\s+"([^"]*)"`)
+	pathPattern       = regexp.MustCompile(`Path:\s+"([^"]*)"`)
+)
+
+// descRoutes returns the "MethodName Path" of each MethodDesc entry.
+func descRoutes(entries []string) []string {
+	routes := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		routes = append(routes, methodNamePattern.FindStringSubmatch(entry)[1]+" "+pathPattern.FindStringSubmatch(entry)[1])
+	}
+	return routes
+}
+
+func TestPackageSuffix(t *testing.T) {
+	file := userFile(testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")))
+	content := generatedContent(t, runPlugin(t, "package_suffix=restgen", file), "example.com/api/restgen/user_rest.pb.go")
+	wantContains(t, content,
+		"\npackage restgen\n",
+		`api "example.com/api"`,
+		"HandlerType: (*api.UserServiceServer)(nil),",
+		"in := new(api.GetUserRequest)",
+	)
+
+	for _, suffix := range []string{"not-valid", "1rest", "rest.gen"} {
+		wantError(t, runPlugin(t, "package_suffix="+suffix, file),
+			fmt.Sprintf("package_suffix %q is not a valid Go package name", suffix))
+	}
+}
+
+func TestSuccessStatus(t *testing.T) {
+	content := generatedContent(t, runPlugin(t, "", userFile(
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")),
+		testMethod("CreateUser", "CreateUserRequest", "User", httpPost("/users", "user")),
+		testMethod("DeleteUser", "GetUserRequest", "User", httpDelete("/users/{id}")),
+	)), userOutput)
+	entries := methodDescs(t, content, "UserServiceRestServiceDesc")
+	if len(entries) != 3 {
+		t.Fatalf("got %d MethodDesc entries, want 3", len(entries))
+	}
+	wantNotContains(t, entries[0], "SuccessStatus")
+	wantContains(t, entries[1], "SuccessStatus: 201,")
+	wantContains(t, entries[2], "SuccessStatus: 204,")
+}
+
+func TestTracing(t *testing.T) {
+	file := userFile(testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")))
+	content := generatedContent(t, runPlugin(t, "tracing=true", file), userOutput)
+	wantContains(t, content,
+		`trace "go.opentelemetry.io/otel/trace"`,
+		`Handler: func(ctx *rest.Context, srv any, interceptor server.UnaryServerInterceptor) (any, error) {
+			trace.SpanFromContext(ctx).SetAttributes(
+				attribute.String("http.route", "/api/v1/users/{id}"),
+				attribute.String("rpc.method", "api.v1.UserService.GetUser"),
+			)
+			return _UserService_GetUser_RestHandler(ctx, srv, interceptor)
+		},`,
+	)
+
+	content = generatedContent(t, runPlugin(t, "", file), userOutput)
+	wantContains(t, content, "Handler: _UserService_GetUser_RestHandler,")
+	wantNotContains(t, content, "go.opentelemetry.io", "SetAttributes")
+}
+
+func TestHandlerTypeName(t *testing.T) {
+	file := userFile(testMethod("GetUser", "GetUserRequest", "User", httpGet("/v1/users/{id}")))
+	content := generatedContent(t, runPlugin(t, "", file), userOutput)
+	wantContains(t, content, `var UserServiceRestServiceDesc = rest.ServiceDesc{
+		ServiceName:     "api.v1.UserService",
+		HandlerType:     (*UserServiceServer)(nil),
+		HandlerTypeName: "UserServiceServer",`)
+
+	content = generatedContent(t, runPlugin(t, "version_grouping=true", file), userOutput)
+	for _, descVar := range []string{"UserServiceRestServiceDesc", "UserServiceV1RestServiceDesc"} {
+		wantContains(t, content, `var `+descVar+` = rest.ServiceDesc{
+			ServiceName:     "api.v1.UserService",
+			HandlerType:     (*UserServiceServer)(nil),
+			HandlerTypeName: "UserServiceServer",`)
+	}
+}
+
+func TestHandlerVars(t *testing.T) {
+	file := userFile(testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")))
+	content := generatedContent(t, runPlugin(t, "handler_vars=true", file), userOutput)
+	wantContains(t, content,
+		"var _UserService_GetUser_RestHandler = func(ctx *rest.Context, srv any, interceptor server.UnaryServerInterceptor) (any, error) {",
+		"Handler: func(ctx *rest.Context, srv any, interceptor server.UnaryServerInterceptor) (any, error) {\n"+
+			"return _UserService_GetUser_RestHandler(ctx, srv, interceptor)",
+	)
+	wantNotContains(t, content, "func _UserService_GetUser_RestHandler(")
+
+	content = generatedContent(t, runPlugin(t, "", file), userOutput)
+	wantContains(t, content, "func _UserService_GetUser_RestHandler(", "Handler: _UserService_GetUser_RestHandler,")
+	wantNotContains(t, content, "var _UserService_GetUser_RestHandler")
+}
+
+func TestDescSelfcheck(t *testing.T) {
+	content := generatedContent(t, runPlugin(t, "desc_selfcheck=true", userFile(
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")),
+	)), userOutput)
+	wantContains(t, content,
+		`func init() {
+			if err := checkUserServiceRestServiceDesc(); err != nil {
+				panic(err)
+			}
+		}`,
+		`case method.Path == "":`,
+		`case method.Handler == nil:`,
+	)
+}
+
+// TestDescSelfcheckRejectsCorruptedDesc runs the generated check against
+// descriptors with a field cleared, in a throwaway module that stubs the few
+// rest types the check reads.
+func TestDescSelfcheckRejectsCorruptedDesc(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("needs the go tool to build the generated check")
+	}
+	content := generatedContent(t, runPlugin(t, "desc_selfcheck=true", userFile(
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")),
+	)), userOutput)
+	start := strings.Index(content, "func checkUserServiceRestServiceDesc() error {")
+	if start < 0 {
+		t.Fatalf("no checkUserServiceRestServiceDesc in generated code:\n%s", content)
+	}
+	check := content[start:]
+	check = check[:strings.Index(check, "\n}\n")+3]
+
+	dir := t.TempDir()
+	program := `package main
+
+import "fmt"
+
+type MethodDesc struct {
+	MethodName, Method, Path string
+	Handler                  func()
+}
+
+type ServiceDesc struct {
+	ServiceName string
+	Methods     []MethodDesc
+}
+
+var UserServiceRestServiceDesc ServiceDesc
+
+` + check + `
+func main() {
+	for _, method := range []MethodDesc{
+		{MethodName: "GetUser", Method: "GET", Path: "/users/{id}", Handler: func() {}},
+		{MethodName: "GetUser", Path: "/users/{id}", Handler: func() {}},
+		{MethodName: "GetUser", Method: "GET", Handler: func() {}},
+		{MethodName: "GetUser", Method: "GET", Path: "/users/{id}"},
+	} {
+		UserServiceRestServiceDesc = ServiceDesc{ServiceName: "api.v1.UserService", Methods: []MethodDesc{method}}
+		fmt.Println(checkUserServiceRestServiceDesc())
+	}
+}
+`
+	for name, data := range map[string]string{"go.mod": "module selfcheck\n\ngo 1.18\n", "main.go": program} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GOPROXY=off", "GOWORK=off")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run the generated check: %v\n%s", err, out)
+	}
+	want := strings.Join([]string{
+		"<nil>",
+		"api.v1.UserService: method 0 (GetUser) has no HTTP method",
+		"api.v1.UserService: method 0 (GetUser) has no path",
+		"api.v1.UserService: method 0 (GetUser) has no handler",
+	}, "\n") + "\n"
+	if string(out) != want {
+		t.Errorf("generated check reported:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+// TestBrokenHTTPAnnotation checks that annotations the self-check would
+// reject at startup already fail generation.
+func TestBrokenHTTPAnnotation(t *testing.T) {
+	tests := []struct {
+		name       string
+		httpOption *annotations.Http
+		want       string
+	}{
+		{
+			name:       "no pattern",
+			httpOption: &annotations.Http{Body: "*"},
+			want:       "api.v1.UserService.GetUser (user.proto): http annotation sets no pattern",
+		},
+		{
+			name:       "empty path",
+			httpOption: httpGet(""),
+			want:       "api.v1.UserService.GetUser (user.proto): http GET pattern has an empty path",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wantError(t, runPlugin(t, "desc_selfcheck=true", userFile(
+				testMethod("GetUser", "GetUserRequest", "User", test.httpOption),
+			)), test.want)
+		})
+	}
+}
+
+func TestDeprecatedMethods(t *testing.T) {
+	deleteUser := testMethod("DeleteUser", "GetUserRequest", "User", httpDelete("/users/{id}"))
+	deleteUser.Options.Deprecated = proto.Bool(true)
+	file := userFile(
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")),
+		deleteUser,
+	)
+	content := generatedContent(t, runPlugin(t, "", file), userOutput)
+	entries := methodDescs(t, content, "UserServiceRestServiceDesc")
+	if len(entries) != 2 {
+		t.Fatalf("got %d MethodDesc entries, want 2", len(entries))
+	}
+	wantNotContains(t, entries[0], "Deprecated")
+	wantContains(t, entries[1], "Deprecated: true,")
+	wantContains(t, content, "// Deprecated: Do not use.\nfunc _UserService_DeleteUser_RestHandler(")
+	wantNotContains(t, content, "// Deprecated: Do not use.\nfunc _UserService_GetUser_RestHandler(")
+
+	file.Options.Deprecated = proto.Bool(true)
+	content = generatedContent(t, runPlugin(t, "", file), userOutput)
+	for _, entry := range methodDescs(t, content, "UserServiceRestServiceDesc") {
+		wantContains(t, entry, "Deprecated: true,")
+	}
+	wantContains(t, content,
+		"// Deprecated: Do not use.\nfunc _UserService_GetUser_RestHandler(",
+		"// Deprecated: Do not use.\nfunc _UserService_DeleteUser_RestHandler(",
+	)
+}
+
+func TestSourceRefs(t *testing.T) {
+	deprecated := testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}"))
+	deprecated.Options.Deprecated = proto.Bool(true)
+	file := userFile(deprecated)
+	locateMethod(file, 0, 0, 41, "")
+	content := generatedContent(t, runPlugin(t, "source_refs=true", file), userOutput)
+	wantContains(t, content,
+		`// defined at user.proto:42
+		//
+		// Deprecated: Do not use.
+		func _UserService_GetUser_RestHandler(`,
+		`// defined at user.proto:42
+		{
+			MethodName: "GetUser",`,
+	)
+
+	content = generatedContent(t, runPlugin(t, "", file), userOutput)
+	wantNotContains(t, content, "defined at")
+}
+
+// TestHTTPMethodConstants checks that standard verbs are emitted as the
+// runtime's typed constants and custom ones as string literals.
+func TestHTTPMethodConstants(t *testing.T) {
+	custom := &annotations.Http{Pattern: &annotations.Http_Custom{Custom: &annotations.CustomHttpPattern{Kind: "OPTIONS", Path: "/users"}}}
+	content := generatedContent(t, runPlugin(t, "", userFile(
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")),
+		testMethod("ReplaceUser", "CreateUserRequest", "User", httpPut("/users/{user.id}", "user")),
+		testMethod("CreateUser", "CreateUserRequest", "User", httpPost("/users", "user")),
+		testMethod("DeleteUser", "GetUserRequest", "User", httpDelete("/users/{id}")),
+		testMethod("UpdateUser", "CreateUserRequest", "User", httpPatch("/users/{user.id}", "user")),
+		testMethod("HeadUser", "GetUserRequest", "User", &annotations.Http{Pattern: &annotations.Http_Head{Head: "/users/{id}"}}),
+		testMethod("ListOptions", "GetUserRequest", "User", custom),
+	)), userOutput)
+	entries := methodDescs(t, content, "UserServiceRestServiceDesc")
+	want := []string{
+		"Method: rest.MethodGet,",
+		"Method: rest.MethodPut,",
+		"Method: rest.MethodPost,",
+		"Method: rest.MethodDelete,",
+		"Method: rest.MethodPatch,",
+		"Method: rest.MethodHead,",
+		`Method: "OPTIONS",`,
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d MethodDesc entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		wantContains(t, entry, want[i])
+	}
+	wantNotContains(t, content, `Method: "GET"`, `Method: "POST"`)
+}
+
+func TestFormBody(t *testing.T) {
+	file := func(body string) *descriptorpb.FileDescriptorProto {
+		return testFile("login.proto", "api.v1",
+			[]*descriptorpb.DescriptorProto{
+				testMessage("Credentials", stringField("username"), stringField("password")),
+				testMessage("Session", stringField("token")),
+				testMessage("LoginRequest", stringField("tenant"), messageField("credentials", "Credentials"), messageField("session", "Session")),
+				testMessage("FormLoginRequest", stringField("tenant"), stringField("username"), stringField("password")),
+				testMessage("LoginReply", stringField("token")),
+			},
+			testService("LoginService",
+				testMethod("FormLogin", "FormLoginRequest", "LoginReply", httpPost("/tenants/{tenant}/login", "*")),
+				testMethod("Login", "LoginRequest", "LoginReply", httpPost("/tenants/{tenant}/sessions", body)),
+				testMethod("Logout", "LoginRequest", "LoginReply", httpPost("/logout", "")),
+			),
+		)
+	}
+
+	content := generatedContent(t, runPlugin(t, "form_body=true", file("credentials")), "example.com/api/login_rest.pb.go")
+	entries := methodDescs(t, content, "LoginServiceRestServiceDesc")
+	if len(entries) != 3 {
+		t.Fatalf("got %d MethodDesc entries, want 3", len(entries))
+	}
+	wantContains(t, entries[0], `Consumes: []string{"application/x-www-form-urlencoded"},`)
+	wantContains(t, entries[1], `Consumes: []string{"application/x-www-form-urlencoded"},`)
+	wantNotContains(t, entries[2], "Consumes")
+
+	wantError(t, runPlugin(t, "form_body=true", file("*")),
+		"api.v1.LoginService.Login (login.proto): form_body: field credentials of api.v1.LoginRequest is a message and can't be form-encoded")
+}
+
+func TestImplicitBody(t *testing.T) {
+	file := userFile(
+		testMethod("CreateUser", "CreateUserRequest", "User", httpPost("/users", "")),
+		testMethod("ReplaceUser", "CreateUserRequest", "User", httpPut("/users/{user.id}", "")),
+		testMethod("UpdateUser", "CreateUserRequest", "User", httpPatch("/users/{user.id}", "")),
+		testMethod("ImportUser", "CreateUserRequest", "User", httpPost("/users:import", "user")),
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")),
+		testMethod("DeleteUser", "GetUserRequest", "User", httpDelete("/users/{id}")),
+	)
+	content := generatedContent(t, runPlugin(t, "implicit_body=true", file), userOutput)
+	entries := methodDescs(t, content, "UserServiceRestServiceDesc")
+	if len(entries) != 6 {
+		t.Fatalf("got %d MethodDesc entries, want 6", len(entries))
+	}
+	for _, entry := range entries[:3] {
+		wantContains(t, entry, `Body: "*",`)
+	}
+	wantContains(t, entries[3], `Body: "user",`)
+	for _, entry := range entries[4:] {
+		wantNotContains(t, entry, "Body:")
+	}
+
+	content = generatedContent(t, runPlugin(t, "", file), userOutput)
+	wantNotContains(t, content, `Body: "*"`)
+}
+
+func TestMergePatch(t *testing.T) {
+	file := userFile(
+		testMethod("UpdateUser", "CreateUserRequest", "User", httpPatch("/users/{user.id}", "user")),
+		testMethod("CreateUser", "CreateUserRequest", "User", httpPost("/users", "user")),
+	)
+	content := generatedContent(t, runPlugin(t, "merge_patch=true", file), userOutput)
+	entries := methodDescs(t, content, "UserServiceRestServiceDesc")
+	if len(entries) != 2 {
+		t.Fatalf("got %d MethodDesc entries, want 2", len(entries))
+	}
+	wantContains(t, entries[0], `Consumes: []string{"application/merge-patch+json"},`)
+	wantNotContains(t, entries[1], "Consumes")
+
+	content = generatedContent(t, runPlugin(t, "", file), userOutput)
+	wantNotContains(t, content, "Consumes", "merge-patch")
+}
+
+func TestGeneratedCodeIsGofmtClean(t *testing.T) {
+	watch := testMethod("WatchUser", "GetUserRequest", "User", httpGet("/v2/users/{id}:watch"))
+	watch.ServerStreaming = proto.Bool(true)
+	file := userFile(
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/v1/users/{id}")),
+		testMethod("CreateUser", "CreateUserRequest", "User", httpPost("/v1/users", "user")),
+		testMethod("DeleteUser", "GetUserRequest", "User", httpDelete("/v2/users/{id}")),
+		watch,
+	)
+	locateMethod(file, 0, 0, 10, " Gets a user.\n")
+	tests := []struct {
+		parameter, output string
+	}{
+		{"", userOutput},
+		{"tracing=true,source_refs=true", userOutput},
+		{"handler_vars=true,desc_selfcheck=true,embed_routes_json=true", userOutput},
+		{"server_info_registry=true,version_grouping=true,max_query_bytes=8192,server_streams=true", userOutput},
+		{"package_suffix=rest,merge_patch=true,form_body=true,strip_comments=true,server_streams=true", "example.com/api/rest/user_rest.pb.go"},
+	}
+	for _, test := range tests {
+		content := generatedContent(t, runPlugin(t, test.parameter, file), test.output)
+		formatted, err := format.Source([]byte(content))
+		if err != nil {
+			t.Errorf("%q: generated code doesn't format: %v", test.parameter, err)
+			continue
+		}
+		if string(formatted) != content {
+			t.Errorf("%q: generated code is not gofmt-clean:\n%s", test.parameter, content)
+		}
+	}
+}
+
+func TestRoutesJSON(t *testing.T) {
+	deprecated := testMethod("DeleteUser", "GetUserRequest", "User", httpDelete("/users/{id}"))
+	deprecated.Options.Deprecated = proto.Bool(true)
+	file := userFile(
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")),
+		deprecated,
+	)
+	locateMethod(file, 0, 0, 10, " Gets a `user`. By id.\n")
+	content := generatedContent(t, runPlugin(t, "embed_routes_json=true", file), userOutput)
+
+	const prefix = "var UserServiceRestRoutesJSON = "
+	start := strings.Index(content, prefix)
+	if start < 0 {
+		t.Fatalf("no UserServiceRestRoutesJSON in generated code:\n%s", content)
+	}
+	expr, err := parser.ParseExpr(strings.SplitN(content[start+len(prefix):], "\n\n", 2)[0])
+	if err != nil {
+		t.Fatalf("parse UserServiceRestRoutesJSON: %v", err)
+	}
+	literal, err := strconv.Unquote(expr.(*ast.BasicLit).Value)
+	if err != nil {
+		t.Fatalf("unquote UserServiceRestRoutesJSON: %v", err)
+	}
+	var routes []restRoute
+	if err := json.Unmarshal([]byte(literal), &routes); err != nil {
+		t.Fatalf("UserServiceRestRoutesJSON is not valid JSON: %v\n%s", err, literal)
+	}
+	want := []restRoute{
+		{FullMethod: "api.v1.UserService.GetUser", Method: "GET", Path: "/api/v1/users/{id}", Summary: "Gets a `user`."},
+		{FullMethod: "api.v1.UserService.DeleteUser", Method: "DELETE", Path: "/api/v1/users/{id}", Deprecated: true},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("UserServiceRestRoutesJSON = %+v, want %+v", routes, want)
+	}
+}
+
+func TestMethodGoNameCollision(t *testing.T) {
+	file := userFile(
+		testMethod("get_user", "GetUserRequest", "User", httpGet("/users/{id}")),
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/v2/users/{id}")),
+	)
+	locateMethod(file, 0, 0, 10, "")
+	locateMethod(file, 0, 1, 12, "")
+	wantError(t, runPlugin(t, "", file),
+		"api.v1.UserService: methods get_user (user.proto:11) and GetUser (user.proto:13) both map to Go name GetUser")
+}
+
+func TestServerInfoRegistry(t *testing.T) {
+	watch := testMethod("WatchUsers", "GetUserRequest", "User", httpGet("/users:watch"))
+	watch.ClientStreaming = proto.Bool(true)
+	createUser := testMethod("CreateUser", "CreateUserRequest", "User", httpPost("/users", "user"))
+	createUser.Options.Deprecated = proto.Bool(true)
+	content := generatedContent(t, runPlugin(t, "server_info_registry=true", userFile(
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")),
+		createUser,
+		testMethod("Unrouted", "GetUserRequest", "User"),
+		watch,
+	)), userOutput)
+	wantContains(t, content, `type UserServiceRestServerInfo struct {
+		server.UnaryServerInfo
+		// Deprecated reports whether the method is marked deprecated in its proto file.
+		Deprecated bool
+	}`, `var UserServiceRestServerInfos = []UserServiceRestServerInfo{
+		{
+			UnaryServerInfo: server.UnaryServerInfo{
+				FullMethod: "api.v1.UserService.GetUser",
+				Protocol:   rest.Protocol,
+			},
+		},
+		{
+			UnaryServerInfo: server.UnaryServerInfo{
+				FullMethod: "api.v1.UserService.CreateUser",
+				Protocol:   rest.Protocol,
+			},
+			Deprecated: true,
+		},
+	}`)
+}
+
+func TestMaxQueryBytes(t *testing.T) {
+	file := userFile(testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")))
+	content := generatedContent(t, runPlugin(t, "max_query_bytes=0", file), userOutput)
+	wantNotContains(t, content, "MaxQueryBytes")
+
+	content = generatedContent(t, runPlugin(t, "max_query_bytes=8192", file), userOutput)
+	wantContains(t, content, "MaxQueryBytes: 8192,")
+
+	wantError(t, runPlugin(t, "max_query_bytes=-1", file), "max_query_bytes must not be negative, got -1")
+}
+
+func TestVersionGrouping(t *testing.T) {
+	content := generatedContent(t, runPlugin(t, "version_grouping=true", userFile(
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/v1/users/{id}"), httpGet("/v2/users/{id}")),
+		testMethod("CreateUser", "CreateUserRequest", "User", httpPost("/v2/users", "user")),
+		testMethod("DeleteUser", "GetUserRequest", "User", httpDelete("/users/{id}")),
+	)), userOutput)
+	tests := []struct {
+		descVar string
+		want    []string
+	}{
+		{"UserServiceRestServiceDesc", []string{
+			"GetUser /api/v1/v1/users/{id}",
+			"GetUser /api/v1/v2/users/{id}",
+			"CreateUser /api/v1/v2/users",
+			"DeleteUser /api/v1/users/{id}",
+		}},
+		{"UserServiceV1RestServiceDesc", []string{"GetUser /api/v1/v1/users/{id}"}},
+		{"UserServiceV2RestServiceDesc", []string{
+			"GetUser /api/v1/v2/users/{id}",
+			"CreateUser /api/v1/v2/users",
+		}},
+	}
+	for _, test := range tests {
+		if got := descRoutes(methodDescs(t, content, test.descVar)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s routes = %q, want %q", test.descVar, got, test.want)
+		}
+	}
+}
+
+func TestVersionGroupingNameCollision(t *testing.T) {
+	file := testFile("user.proto", "api.v1",
+		[]*descriptorpb.DescriptorProto{testMessage("Account", stringField("id"))},
+		testService("User", testMethod("GetUser", "Account", "Account", httpGet("/v1/users/{id}"))),
+		testService("UserV1", testMethod("GetUser", "Account", "Account", httpGet("/users/{id}"))),
+	)
+	wantError(t, runPlugin(t, "version_grouping=true", file),
+		"version_grouping: the ServiceDesc of api.v1.UserV1 and the v1 ServiceDesc of api.v1.User are both named UserV1RestServiceDesc")
+}
+
+func TestAdditionalBindings(t *testing.T) {
+	getUser := httpGet("/users/{id}")
+	getUser.AdditionalBindings = []*annotations.Http{
+		httpGet("/orgs/{org}/users/{id}"),
+		httpPost("/users/{id}:get", "*"),
+	}
+	file := testFile("user.proto", "api.v1",
+		[]*descriptorpb.DescriptorProto{
+			testMessage("User", stringField("id")),
+			testMessage("GetUserRequest", stringField("id"), stringField("org")),
+		},
+		testService("UserService", testMethod("GetUser", "GetUserRequest", "User", getUser)),
+	)
+	content := generatedContent(t, runPlugin(t, "", file), userOutput)
+	entries := methodDescs(t, content, "UserServiceRestServiceDesc")
+	want := []string{
+		"GetUser /api/v1/users/{id}",
+		"GetUser /api/v1/orgs/{org}/users/{id}",
+		"GetUser /api/v1/users/{id}:get",
+	}
+	if got := descRoutes(entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("routes = %q, want %q", got, want)
+	}
+	for _, entry := range entries {
+		wantContains(t, entry, "Handler: _UserService_GetUser_RestHandler,")
+	}
+	wantContains(t, entries[2], "Method: rest.MethodPost,", `Body: "*",`)
+
+	getUser = httpGet("/users/{id}")
+	nested := httpGet("/orgs/{org}/users/{id}")
+	nested.AdditionalBindings = []*annotations.Http{httpGet("/v2/users/{id}")}
+	getUser.AdditionalBindings = []*annotations.Http{nested}
+	wantError(t, runPlugin(t, "", userFile(testMethod("GetUser", "GetUserRequest", "User", getUser))),
+		"api.v1.UserService.GetUser (user.proto): additional_bindings must not be nested")
+}
+
+func TestCustomPattern(t *testing.T) {
+	custom := func(kind string) *annotations.Http {
+		return &annotations.Http{Pattern: &annotations.Http_Custom{Custom: &annotations.CustomHttpPattern{Kind: kind, Path: "/users:batchGet"}}}
+	}
+	content := generatedContent(t, runPlugin(t, "", userFile(
+		testMethod("BatchGetUsers", "GetUserRequest", "User", custom("OPTIONS")),
+	)), userOutput)
+	wantContains(t, content, `Method: "OPTIONS",
+		Path: "/api/v1/users:batchGet",`)
+
+	wantError(t, runPlugin(t, "", userFile(
+		testMethod("BatchGetUsers", "GetUserRequest", "User", custom("")),
+	)), `api.v1.UserService.BatchGetUsers (user.proto): custom http pattern for path "/users:batchGet" has an empty kind`)
+}
+
+func booksFile(path string) *descriptorpb.FileDescriptorProto {
+	return testFile("books.proto", "api.v1",
+		[]*descriptorpb.DescriptorProto{
+			testMessage("Book", stringField("id"), stringField("title")),
+			testMessage("GetBookRequest", stringField("shelf_id"), messageField("book", "Book"), repeatedField(stringField("ids"))),
+		},
+		testService("BookService", testMethod("GetBook", "GetBookRequest", "Book", httpGet(path))),
+	)
+}
+
+func TestPathParams(t *testing.T) {
+	content := generatedContent(t, runPlugin(t, "", booksFile("/shelves/{shelf_id}/books/{book.id=books/*}")), "example.com/api/books_rest.pb.go")
+	wantContains(t, content,
+		`Path: "/api/v1/shelves/{shelf_id}/books/{book.id=books/*}",`,
+		`PathParams: []string{"shelf_id", "book.id"},`,
+	)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/books/{missing}", "path variable {missing}: api.v1.GetBookRequest has no field missing"},
+		{"/books/{book.missing}", "path variable {book.missing}: api.v1.Book has no field missing"},
+		{"/books/{ids}", "path variable {ids}: field ids is not a singular scalar"},
+		{"/books/{book}", "path variable {book}: field book is not a singular scalar"},
+		{"/books/{shelf_id.id}", "path variable {shelf_id.id}: field shelf_id is not a singular message"},
+	}
+	for _, test := range tests {
+		wantError(t, runPlugin(t, "", booksFile(test.path)), "api.v1.BookService.GetBook (books.proto): "+test.want)
+	}
+}
+
+func TestLROPathParams(t *testing.T) {
+	file := testFile("operations.proto", "google.longrunning",
+		[]*descriptorpb.DescriptorProto{
+			testMessage("Operation", stringField("name")),
+			testMessage("GetOperationRequest", stringField("name")),
+			testMessage("CancelOperationRequest", stringField("name")),
+		},
+		testService("Operations",
+			testMethod("GetOperation", "GetOperationRequest", "Operation"),
+			testMethod("CancelOperation", "CancelOperationRequest", ".google.protobuf.Empty"),
+		),
+	)
+	file.Dependency = append(file.Dependency, "google/protobuf/empty.proto")
+	content := generatedContent(t, runPlugin(t, "lro_routes=true", file), "example.com/api/operations_rest.pb.go")
+	entries := methodDescs(t, content, "OperationsRestServiceDesc")
+	want := []string{
+		"GetOperation /google/longrunning/{name=operations/**}",
+		"CancelOperation /google/longrunning/{name=operations/**}:cancel",
+	}
+	if got := descRoutes(entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("routes = %q, want %q", got, want)
+	}
+	for _, entry := range entries {
+		wantContains(t, entry, `PathParams: []string{"name"},`)
+	}
+	wantContains(t, entries[1], `Body: "*",`)
+}
+
+// captureWarnings collects the warnings of the test's runs.
+func captureWarnings(t *testing.T) *strings.Builder {
+	t.Helper()
+	var buf strings.Builder
+	warnings = &buf
+	t.Cleanup(func() { warnings = os.Stderr })
+	return &buf
+}
+
+func TestStreamingMethods(t *testing.T) {
+	streaming := func(name string, client, server bool, httpOptions ...*annotations.Http) *descriptorpb.MethodDescriptorProto {
+		method := testMethod(name, "GetUserRequest", "User", httpOptions...)
+		method.ClientStreaming = proto.Bool(client)
+		method.ServerStreaming = proto.Bool(server)
+		return method
+	}
+	file := userFile(
+		testMethod("GetUser", "GetUserRequest", "User", httpGet("/users/{id}")),
+		streaming("Watch", false, true, httpGet("/users/{id}:watch")),
+		streaming("Upload", true, false, httpPost("/users:upload", "*")),
+		streaming("Chat", true, true, httpPost("/users:chat", "*")),
+		streaming("Sync", true, true),
+	)
+	warned := captureWarnings(t)
+	content := generatedContent(t, runPlugin(t, "server_streams=true", file), userOutput)
+	wantContains(t, content,
+		`type _UserService_Watch_RestServerStream struct {
+			rest.ServerStream
+		}
+
+		var _ UserService_WatchServer = (*_UserService_Watch_RestServerStream)(nil)`,
+		`func (x *_UserService_Watch_RestServerStream) Send(m *User) error {
+			return x.ServerStream.SendMsg(m)
+		}`,
+		`func _UserService_Watch_RestHandler(ctx *rest.Context, srv any, interceptor server.UnaryServerInterceptor) (any, error) {
+			in := new(GetUserRequest)
+			stream := &_UserService_Watch_RestServerStream{ServerStream: rest.NewServerStream(ctx)}
+			if interceptor == nil {
+				return nil, srv.(UserServiceServer).Watch(in, stream)
+			}`,
+		`return nil, srv.(UserServiceServer).Watch(in, stream)
+			}
+			return interceptor(ctx, in, info, handler)`,
+	)
+	entries := methodDescs(t, content, "UserServiceRestServiceDesc")
+	if got, want := descRoutes(entries), []string{"GetUser /api/v1/users/{id}", "Watch /api/v1/users/{id}:watch"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("routes = %q, want %q", got, want)
+	}
+	wantContains(t, entries[1], "ServerStreams: true,")
+	wantNotContains(t, content, "_UserService_Upload_", "_UserService_Chat_", "_UserService_Sync_")
+	want := "protoc-gen-go-rest: WARNING: api.v1.UserService.Upload: client and bidirectional streaming are not supported over rest, method skipped\n" +
+		"protoc-gen-go-rest: WARNING: api.v1.UserService.Chat: client and bidirectional streaming are not supported over rest, method skipped\n"
+	if warned.String() != want {
+		t.Errorf("warnings:\n%s\nwant:\n%s", warned, want)
+	}
+
+	warned.Reset()
+	content = generatedContent(t, runPlugin(t, "", file), userOutput)
+	if got, want := descRoutes(methodDescs(t, content, "UserServiceRestServiceDesc")), []string{"GetUser /api/v1/users/{id}"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("routes without server_streams = %q, want %q", got, want)
+	}
+	wantNotContains(t, content, "_UserService_Watch_", "ServerStream")
+	if !strings.HasPrefix(warned.String(), "protoc-gen-go-rest: WARNING: api.v1.UserService.Watch: server streaming needs server_streams=true, method skipped\n") {
+		t.Errorf("warnings without server_streams:\n%s", warned)
+	}
+}